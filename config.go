@@ -0,0 +1,603 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/parser"
+	"github.com/hashicorp/hcl/hcl/token"
+	"github.com/mitchellh/gox/gox"
+)
+
+// ConfigFiles are the names of the config files that are discovered
+// automatically, in the order they're searched for.
+var ConfigFiles = []string{"gox.hcl", "gox.json"}
+
+// Config is a project configuration file. It declares the same settings
+// as the command-line flags so that long gox invocations can be checked
+// in next to the code. Anything set on the command-line takes precedence
+// over the config.
+type Config struct {
+	Packages     []string `hcl:"packages"`
+	OS           []string `hcl:"os"`
+	Arch         []string `hcl:"arch"`
+	OSArch       []string `hcl:"osarch"`
+	Output       string   `hcl:"output"`
+	Parallel     int      `hcl:"parallel"`
+	Ldflags      string   `hcl:"ldflags"`
+	Gcflags      string   `hcl:"gcflags"`
+	Asmflags     string   `hcl:"asmflags"`
+	Tags         string   `hcl:"tags"`
+	Mod          string   `hcl:"mod"`
+	Cgo          bool     `hcl:"cgo"`
+	Race         bool     `hcl:"race"`
+	Rebuild      bool     `hcl:"rebuild"`
+	GoCmd        string   `hcl:"gocmd"`
+	Timeout      string   `hcl:"timeout"`
+	Version      string   `hcl:"version"`
+	NoExt        bool     `hcl:"no-ext"`
+	BuildMode    string   `hcl:"buildmode"`
+	Trimpath     bool     `hcl:"trimpath"`
+	Reproducible bool     `hcl:"reproducible"`
+	X            []string `hcl:"x"`
+
+	// Platforms are the per-platform overrides, keyed by os/arch or
+	// os/arch/variant.
+	Platforms []*PlatformConfig `hcl:"platform"`
+
+	// Path is the path the config was loaded from.
+	Path string `hcl:"-"`
+
+	// cliFlags are the flags that were set on the command-line, as found
+	// by ApplyFlags. The platform blocks don't override them.
+	cliFlags map[string]struct{}
+}
+
+// PlatformConfig overrides settings for a single os/arch pair, or a
+// single variant of one. These
+// are applied before the GOX_[OS]_[ARCH]_* environment variables, so the
+// environment can still override a checked-in config.
+type PlatformConfig struct {
+	Name      string `hcl:",key"`
+	Output    string `hcl:"output"`
+	Ldflags   string `hcl:"ldflags"`
+	Gcflags   string `hcl:"gcflags"`
+	Asmflags  string `hcl:"asmflags"`
+	Tags      string `hcl:"tags"`
+	Timeout   string `hcl:"timeout"`
+	BuildMode string `hcl:"buildmode"`
+
+	// X are more link variables for the platform, added to those from
+	// the top level.
+	X []string `hcl:"x"`
+
+	// These configure the C toolchain for cgo builds. Cgo can only be
+	// enabled here, since it is disabled by default for cross builds.
+	Cgo           bool   `hcl:"cgo"`
+	CC            string `hcl:"cc"`
+	CXX           string `hcl:"cxx"`
+	CFlags        string `hcl:"cgo-cflags"`
+	CXXFlags      string `hcl:"cgo-cxxflags"`
+	LDFlags       string `hcl:"cgo-ldflags"`
+	PkgConfigPath string `hcl:"pkg-config-path"`
+	Sysroot       string `hcl:"sysroot"`
+}
+
+// configKeys are the valid keys at the top level of a config.
+var configKeys = map[string]struct{}{
+	"packages":     {},
+	"os":           {},
+	"arch":         {},
+	"osarch":       {},
+	"output":       {},
+	"parallel":     {},
+	"ldflags":      {},
+	"gcflags":      {},
+	"asmflags":     {},
+	"tags":         {},
+	"mod":          {},
+	"cgo":          {},
+	"race":         {},
+	"rebuild":      {},
+	"gocmd":        {},
+	"timeout":      {},
+	"version":      {},
+	"no-ext":       {},
+	"buildmode":    {},
+	"trimpath":     {},
+	"reproducible": {},
+	"x":            {},
+	"platform":     {},
+}
+
+// platformConfigKeys are the valid keys within a platform block.
+var platformConfigKeys = map[string]struct{}{
+	"output":    {},
+	"ldflags":   {},
+	"gcflags":   {},
+	"asmflags":  {},
+	"tags":      {},
+	"timeout":   {},
+	"buildmode": {},
+	"x":         {},
+
+	"cgo":             {},
+	"cc":              {},
+	"cxx":             {},
+	"cgo-cflags":      {},
+	"cgo-cxxflags":    {},
+	"cgo-ldflags":     {},
+	"pkg-config-path": {},
+	"sysroot":         {},
+}
+
+// FindConfig looks for a config file in the root of the current module,
+// or the working directory if we're not in a module. An empty path is
+// returned if there is no config file.
+func FindConfig(GoCmd string) (string, error) {
+	dir := ""
+	if gomod, err := gox.GoEnv(GoCmd, "GOMOD"); err == nil {
+		if gomod != "" && gomod != os.DevNull {
+			dir = filepath.Dir(gomod)
+		}
+	}
+
+	if dir == "" {
+		var err error
+		dir, err = os.Getwd()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	for _, name := range ConfigFiles {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+
+	return "", nil
+}
+
+// LoadConfig loads and validates the config at the given path. The file
+// may be either HCL or JSON. All problems in the file are reported at
+// once, each prefixed with the position it was found at.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := hcl.ParseBytes(data)
+	if err != nil {
+		return nil, configError(path, err)
+	}
+
+	// Check the keys first so that typos are reported as such, rather
+	// than silently being ignored by the decoder.
+	c := &configChecker{path: path}
+	list, ok := file.Node.(*ast.ObjectList)
+	if ok {
+		c.checkKeys(list)
+	}
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+	if ok {
+		c.checkValues(list)
+	}
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := hcl.DecodeObject(&config, file); err != nil {
+		return nil, configError(path, err)
+	}
+
+	// Output paths and package patterns like ./cmd/... are relative to
+	// the config so that the same config works no matter where gox is run
+	// from within the project.
+	config.Path = path
+	config.Output = relTemplate(filepath.Dir(path), config.Output)
+	for _, p := range config.Platforms {
+		p.Output = relTemplate(filepath.Dir(path), p.Output)
+	}
+	for i, pkg := range config.Packages {
+		if build.IsLocalImport(pkg) {
+			config.Packages[i] = filepath.Join(filepath.Dir(path), pkg)
+		}
+	}
+
+	return &config, nil
+}
+
+// ApplyFlags sets every flag that wasn't given on the command-line to the
+// value from the config. The platform flags are handled separately by
+// ApplyPlatformFlag.
+func (c *Config) ApplyFlags(flags *flag.FlagSet) error {
+	set := make(map[string]struct{})
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = struct{}{}
+	})
+	c.cliFlags = set
+
+	values := map[string]string{
+		"output":    c.Output,
+		"ldflags":   c.Ldflags,
+		"gcflags":   c.Gcflags,
+		"asmflags":  c.Asmflags,
+		"tags":      c.Tags,
+		"mod":       c.Mod,
+		"gocmd":     c.GoCmd,
+		"timeout":   c.Timeout,
+		"version":   c.Version,
+		"buildmode": c.BuildMode,
+	}
+	if c.Parallel > 0 {
+		values["parallel"] = strconv.Itoa(c.Parallel)
+	}
+	for name, v := range map[string]bool{"cgo": c.Cgo, "race": c.Race, "rebuild": c.Rebuild, "no-ext": c.NoExt, "trimpath": c.Trimpath, "reproducible": c.Reproducible} {
+		if v {
+			values[name] = "true"
+		}
+	}
+
+	for name, v := range values {
+		if v == "" {
+			continue
+		}
+		if _, ok := set[name]; ok {
+			continue
+		}
+
+		if err := flags.Set(name, v); err != nil {
+			return fmt.Errorf("%s: %s: %s", c.Path, name, err)
+		}
+	}
+
+	// -X may be given many times, so each value is set separately
+	if _, ok := set["X"]; !ok {
+		for _, v := range c.X {
+			if err := flags.Set("X", v); err != nil {
+				return fmt.Errorf("%s: x: %s", c.Path, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ApplyPlatformFlag adds the targets from the config to the given
+// PlatformFlag. The caller should only do this if none of the platform
+// flags were given on the command-line.
+func (c *Config) ApplyPlatformFlag(p *gox.PlatformFlag) error {
+	if err := p.AddOS(c.OS...); err != nil {
+		return err
+	}
+	if err := p.AddArch(c.Arch...); err != nil {
+		return err
+	}
+
+	return p.AddOSArch(c.OSArch...)
+}
+
+// Override applies the platform block for the platform of opts, if there
+// is one. For a platform with a variant, the block for its os/arch is
+// applied first, followed by the block for the variant. Settings whose
+// flag was given on the command-line, as found by ApplyFlags, are left
+// alone so that the command-line always wins.
+func (c *Config) Override(opts *gox.CompileOpts) {
+	names := []string{opts.Platform.OS + "/" + opts.Platform.Arch}
+	if opts.Platform.Variant != "" {
+		names = append(names, opts.Platform.String())
+	}
+
+	for _, name := range names {
+		c.override(opts, name)
+	}
+}
+
+func (c *Config) override(opts *gox.CompileOpts, name string) {
+	for _, p := range c.Platforms {
+		if p.Name != name {
+			continue
+		}
+
+		// The C toolchain settings don't have flags, so they are always
+		// applied.
+		for _, o := range []struct {
+			target *string
+			value  string
+			flag   string
+		}{
+			{&opts.OutputTpl, p.Output, "output"},
+			{&opts.Ldflags, p.Ldflags, "ldflags"},
+			{&opts.Gcflags, p.Gcflags, "gcflags"},
+			{&opts.Asmflags, p.Asmflags, "asmflags"},
+			{&opts.Tags, p.Tags, "tags"},
+			{&opts.BuildMode, p.BuildMode, "buildmode"},
+			{&opts.CgoOpts.CC, p.CC, ""},
+			{&opts.CgoOpts.CXX, p.CXX, ""},
+			{&opts.CgoOpts.CFlags, p.CFlags, ""},
+			{&opts.CgoOpts.CXXFlags, p.CXXFlags, ""},
+			{&opts.CgoOpts.LDFlags, p.LDFlags, ""},
+			{&opts.CgoOpts.PkgConfigPath, p.PkgConfigPath, ""},
+			{&opts.CgoOpts.Sysroot, p.Sysroot, ""},
+		} {
+			if o.value != "" && !c.cliFlag(o.flag) {
+				*o.target = o.value
+			}
+		}
+
+		if p.Cgo && !c.cliFlag("cgo") {
+			opts.Cgo = true
+		}
+		// The link variables are shared by every build, which run in
+		// parallel, so they are copied rather than appended to.
+		if !c.cliFlag("X") && len(p.X) > 0 {
+			opts.LinkVars = append(append([]string(nil), opts.LinkVars...), p.X...)
+		}
+
+		// The timeout was validated when the config was loaded.
+		if p.Timeout != "" && !c.cliFlag("timeout") {
+			opts.Timeout, _ = time.ParseDuration(p.Timeout)
+		}
+	}
+}
+
+// cliFlag returns true if the named flag was set on the command-line.
+func (c *Config) cliFlag(name string) bool {
+	_, ok := c.cliFlags[name]
+	return ok
+}
+
+// relTemplate returns the output template tpl relative to dir, unless it
+// is empty or absolute.
+func relTemplate(dir, tpl string) string {
+	if tpl == "" || filepath.IsAbs(tpl) {
+		return tpl
+	}
+
+	// The path is a template so we can't use filepath.Join, which would
+	// clean it and could mangle the template actions.
+	return dir + string(filepath.Separator) + tpl
+}
+
+// configChecker validates the parsed config, collecting every error it
+// finds along with the position of the offending value.
+type configChecker struct {
+	path string
+	errs []string
+}
+
+func (c *configChecker) Err() error {
+	if len(c.errs) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%d error(s) in config:\n\n%s",
+		len(c.errs), strings.Join(c.errs, "\n"))
+}
+
+// errorf adds an error at the position. The JSON parser doesn't keep
+// positions for every value, so the error is only prefixed with the path
+// if the position isn't known.
+func (c *configChecker) errorf(pos token.Pos, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if !pos.IsValid() {
+		c.errs = append(c.errs, fmt.Sprintf("%s: %s", c.path, msg))
+		return
+	}
+
+	c.errs = append(c.errs, fmt.Sprintf("%s:%d:%d: %s",
+		c.path, pos.Line, pos.Column, msg))
+}
+
+func (c *configChecker) checkKeys(list *ast.ObjectList) {
+	for _, item := range list.Items {
+		key := item.Keys[0].Token.Value().(string)
+		if _, ok := configKeys[key]; !ok {
+			c.errorf(item.Pos(), "unknown key %q", key)
+			continue
+		}
+		if key != "platform" {
+			continue
+		}
+
+		// Platform blocks are either written as `platform "os/arch" {}`,
+		// or in JSON as an object keyed by os/arch.
+		obj, ok := item.Val.(*ast.ObjectType)
+		if !ok {
+			c.errorf(item.Pos(), "platform must be a block")
+			continue
+		}
+		if len(item.Keys) > 1 {
+			c.checkPlatformKeys(obj.List)
+			continue
+		}
+		for _, sub := range obj.List.Items {
+			subObj, ok := sub.Val.(*ast.ObjectType)
+			if !ok {
+				c.errorf(sub.Pos(), "platform must be a block")
+				continue
+			}
+
+			c.checkPlatformKeys(subObj.List)
+		}
+	}
+}
+
+func (c *configChecker) checkPlatformKeys(list *ast.ObjectList) {
+	for _, item := range list.Items {
+		key := item.Keys[0].Token.Value().(string)
+		if _, ok := platformConfigKeys[key]; !ok {
+			c.errorf(item.Pos(), "unknown key %q in platform", key)
+		}
+	}
+}
+
+// checkValues decodes and validates every item in the config on its own,
+// so that each problem is reported at the item it was found in. The keys
+// have already been checked.
+func (c *configChecker) checkValues(list *ast.ObjectList) {
+	seen := make(map[string]struct{})
+	for _, item := range list.Items {
+		if item.Keys[0].Token.Value().(string) == "platform" {
+			for _, block := range platformBlocks(item) {
+				c.checkPlatform(block, seen)
+			}
+			continue
+		}
+
+		var config Config
+		if !c.decode(&config, item, item) {
+			continue
+		}
+
+		if config.Parallel < 0 {
+			c.errorf(item.Val.Pos(), "parallel must not be negative")
+		}
+		for i, v := range config.OSArch {
+			var value gox.PlatformFlag
+			if err := value.AddOSArch(v); err != nil {
+				c.errorf(elemPos(item, i), "%s", err)
+			}
+		}
+		c.checkSetting(item, config.Output, config.Timeout, config.BuildMode, config.X)
+	}
+}
+
+// checkPlatform validates a platform block. A platform that was already
+// seen is reported at the later block.
+func (c *configChecker) checkPlatform(block platformBlock, seen map[string]struct{}) {
+	name := block.name.Token.Value().(string)
+	var value gox.PlatformFlag
+	if err := value.AddOSArch(name); err != nil || len(value.OSArch) != 1 || name[0] == '!' {
+		c.errorf(block.name.Pos(), "platform %q should be os/arch or os/arch/variant", name)
+	}
+	if _, ok := seen[name]; ok {
+		c.errorf(block.name.Pos(), "platform %q declared more than once", name)
+	}
+	seen[name] = struct{}{}
+
+	// Each item is decoded on its own as part of a block of its own, so
+	// that the decoder sets the name of the platform as usual.
+	for _, item := range block.obj.List.Items {
+		var config Config
+		wrapped := &ast.ObjectItem{
+			Keys: []*ast.ObjectKey{
+				{Token: token.Token{Type: token.IDENT, Text: "platform"}},
+				block.name,
+			},
+			Val: &ast.ObjectType{List: &ast.ObjectList{Items: []*ast.ObjectItem{item}}},
+		}
+		if c.decode(&config, wrapped, item) && len(config.Platforms) == 1 {
+			p := config.Platforms[0]
+			c.checkSetting(item, p.Output, p.Timeout, p.BuildMode, p.X)
+		}
+	}
+}
+
+// checkSetting validates the value of an item that may be at the top
+// level or in a platform block. Only the setting named by the item is
+// set, the rest are empty.
+func (c *configChecker) checkSetting(item *ast.ObjectItem, output, timeout, buildMode string, x []string) {
+	pos := item.Val.Pos()
+	if output != "" {
+		if _, err := gox.ParseOutputTemplate(output); err != nil {
+			c.errorf(pos, "invalid output template: %s", err)
+		}
+	}
+	if timeout != "" {
+		if _, err := time.ParseDuration(timeout); err != nil {
+			c.errorf(pos, "invalid duration: %s", err)
+		}
+	}
+	if err := gox.ValidateBuildMode(buildMode); err != nil {
+		c.errorf(pos, "%s", err)
+	}
+	for i, v := range x {
+		if err := gox.ValidateLinkVar(v); err != nil {
+			c.errorf(elemPos(item, i), "%s", err)
+		}
+	}
+}
+
+// decode decodes node, which holds the single item, into out and reports
+// the error at the item if its value has the wrong type, or where the
+// decoder says it is.
+func (c *configChecker) decode(out interface{}, node, item *ast.ObjectItem) bool {
+	err := hcl.DecodeObject(out, &ast.ObjectList{Items: []*ast.ObjectItem{node}})
+	if err == nil {
+		return true
+	}
+
+	pos := item.Pos()
+	if pe, ok := err.(*parser.PosError); ok {
+		pos, err = pe.Pos, pe.Err
+	}
+	c.errorf(pos, "invalid value for %q: %s", item.Keys[0].Token.Value(), err)
+	return false
+}
+
+// platformBlock is a platform block in the config, which is either
+// written as `platform "os/arch" {}`, or in JSON as an object keyed by
+// os/arch.
+type platformBlock struct {
+	name *ast.ObjectKey
+	obj  *ast.ObjectType
+}
+
+// platformBlocks returns the platform blocks in a platform item. Blocks
+// that aren't objects are skipped, since checkKeys reports them.
+func platformBlocks(item *ast.ObjectItem) []platformBlock {
+	obj, ok := item.Val.(*ast.ObjectType)
+	if !ok {
+		return nil
+	}
+	if len(item.Keys) > 1 {
+		return []platformBlock{{name: item.Keys[1], obj: obj}}
+	}
+
+	var result []platformBlock
+	for _, sub := range obj.List.Items {
+		if subObj, ok := sub.Val.(*ast.ObjectType); ok {
+			result = append(result, platformBlock{name: sub.Keys[0], obj: subObj})
+		}
+	}
+
+	return result
+}
+
+// elemPos returns the position of the i'th element of the list that is
+// the value of the item, or of the value if it isn't a list.
+func elemPos(item *ast.ObjectItem, i int) token.Pos {
+	if list, ok := item.Val.(*ast.ListType); ok && i < len(list.List) {
+		return list.List[i].Pos()
+	}
+
+	return item.Val.Pos()
+}
+
+// configError prefixes the error with the path to the config and, if
+// the error has a position, the line and column it occurred on.
+func configError(path string, err error) error {
+	if pe, ok := err.(*parser.PosError); ok {
+		return fmt.Errorf("%s:%d:%d: %s", path, pe.Pos.Line, pe.Pos.Column, pe.Err)
+	}
+
+	return fmt.Errorf("%s: %s", path, err)
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/gox/gox"
+)
+
+func testConfig(t *testing.T, name, contents string) string {
+	td, err := ioutil.TempDir("", "gox")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(td) })
+
+	path := filepath.Join(td, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := testConfig(t, "gox.hcl", `
+packages = ["./cmd/...", "example.com/foo/tools/..."]
+osarch   = ["linux/amd64", "!darwin/386"]
+output   = "dist/{{.Dir}}_{{.Version | trimPrefix \"v\"}}_{{.OS}}_{{.Arch}}"
+parallel = 2
+ldflags  = "-s -w"
+
+platform "windows/amd64" {
+  ldflags = "-H windowsgui"
+}
+`)
+
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !reflect.DeepEqual(c.OSArch, []string{"linux/amd64", "!darwin/386"}) {
+		t.Fatalf("bad: %#v", c.OSArch)
+	}
+	if c.Parallel != 2 || c.Ldflags != "-s -w" {
+		t.Fatalf("bad: %#v", c)
+	}
+
+	expected := filepath.Dir(path) + string(filepath.Separator) + `dist/{{.Dir}}_{{.Version | trimPrefix "v"}}_{{.OS}}_{{.Arch}}`
+	if c.Output != expected {
+		t.Fatalf("bad: %s", c.Output)
+	}
+
+	packages := []string{filepath.Join(filepath.Dir(path), "cmd", "..."), "example.com/foo/tools/..."}
+	if !reflect.DeepEqual(c.Packages, packages) {
+		t.Fatalf("bad: %#v", c.Packages)
+	}
+
+	if len(c.Platforms) != 1 || c.Platforms[0].Name != "windows/amd64" ||
+		c.Platforms[0].Ldflags != "-H windowsgui" {
+		t.Fatalf("bad: %#v", c.Platforms)
+	}
+}
+
+func TestLoadConfig_json(t *testing.T) {
+	path := testConfig(t, "gox.json", `{
+  "os": ["linux", "windows"],
+  "platform": {
+    "windows/386": {"tags": "foo"}
+  }
+}`)
+
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !reflect.DeepEqual(c.OS, []string{"linux", "windows"}) {
+		t.Fatalf("bad: %#v", c.OS)
+	}
+	if len(c.Platforms) != 1 || c.Platforms[0].Name != "windows/386" ||
+		c.Platforms[0].Tags != "foo" {
+		t.Fatalf("bad: %#v", c.Platforms)
+	}
+}
+
+func TestLoadConfig_invalid(t *testing.T) {
+	cases := []struct {
+		Contents string
+		Errors   []string
+	}{
+		{
+			"osarch = [\"linux/amd64\"\n",
+			[]string{"gox.hcl:"},
+		},
+		{
+			"ldflags = \"-s\"\nldflag = \"-w\"\n\nplatform \"linux/arm\" {\n  gcflag = \"\"\n}\n",
+			[]string{
+				`gox.hcl:2:1: unknown key "ldflag"`,
+				`gox.hcl:5:3: unknown key "gcflag" in platform`,
+			},
+		},
+		{
+			"osarch = [\"linux\"]\noutput = \"{{.Dir\"\nparallel = -1\n",
+			[]string{
+				"gox.hcl:1:11: Invalid platform syntax",
+				"gox.hcl:2:10: invalid output template",
+				"gox.hcl:3:12: parallel must not be negative",
+			},
+		},
+		{
+			"buildmode = \"dll\"\n",
+			[]string{"gox.hcl:1:13: unknown build mode: dll"},
+		},
+		{
+			"timeout = \"soon\"\n",
+			[]string{"gox.hcl:1:11: invalid duration"},
+		},
+		{
+			"x = [\"main.version\"]\n",
+			[]string{`gox.hcl:1:6: invalid -X "main.version"`},
+		},
+		{
+			// The values are reported where they are, not where the
+			// same text first appears
+			"x = [\"main.v=1\"]\nbuildmode = \"x\"\n",
+			[]string{"gox.hcl:2:13: unknown build mode: x"},
+		},
+		{
+			"parallel = 2\n\nparallel = \"four\"\n",
+			[]string{`gox.hcl:3:1: invalid value for "parallel"`},
+		},
+		{
+			"platform \"linux/arm\" {}\n\nplatform \"linux/arm\" {}\n",
+			[]string{`gox.hcl:3:10: platform "linux/arm" declared more than once`},
+		},
+		{
+			"platform \"linux\" {}\n",
+			[]string{`gox.hcl:1:10: platform "linux" should be os/arch or os/arch/variant`},
+		},
+	}
+
+	for _, tc := range cases {
+		_, err := LoadConfig(testConfig(t, "gox.hcl", tc.Contents))
+		if err == nil {
+			t.Fatalf("should err: %s", tc.Contents)
+		}
+
+		for _, expected := range tc.Errors {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("expected %q in error:\n%s", expected, err)
+			}
+		}
+	}
+}
+
+func TestConfigApplyFlags(t *testing.T) {
+	var ldflags, tags string
+	var parallel int
+	var cgo bool
+	flags := flag.NewFlagSet("gox", flag.ContinueOnError)
+	flags.StringVar(&ldflags, "ldflags", "", "")
+	flags.StringVar(&tags, "tags", "", "")
+	flags.IntVar(&parallel, "parallel", -1, "")
+	flags.BoolVar(&cgo, "cgo", false, "")
+	flags.String("output", "", "")
+	flags.String("gcflags", "", "")
+	flags.String("asmflags", "", "")
+	flags.String("mod", "", "")
+	flags.String("gocmd", "go", "")
+	flags.Bool("race", false, "")
+	flags.Bool("rebuild", false, "")
+	if err := flags.Parse([]string{"-ldflags", "-X main.foo=bar"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	c := &Config{Ldflags: "-s -w", Tags: "netgo", Parallel: 3, Cgo: true}
+	if err := c.ApplyFlags(flags); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if ldflags != "-X main.foo=bar" {
+		t.Fatalf("command-line should win: %s", ldflags)
+	}
+	if tags != "netgo" || parallel != 3 || !cgo {
+		t.Fatalf("bad: %s %d %v", tags, parallel, cgo)
+	}
+}
+
+func TestConfigOverride(t *testing.T) {
+	c := &Config{
+		Platforms: []*PlatformConfig{
+			{Name: "windows/amd64", Ldflags: "-H windowsgui"},
+		},
+	}
+
+	opts := &gox.CompileOpts{
+		Platform: gox.Platform{OS: "windows", Arch: "amd64"},
+		Ldflags:  "-s",
+		Tags:     "foo",
+	}
+	c.Override(opts)
+	if opts.Ldflags != "-H windowsgui" || opts.Tags != "foo" {
+		t.Fatalf("bad: %#v", opts)
+	}
+
+	opts = &gox.CompileOpts{
+		Platform: gox.Platform{OS: "linux", Arch: "amd64"},
+		Ldflags:  "-s",
+	}
+	c.Override(opts)
+	if opts.Ldflags != "-s" {
+		t.Fatalf("bad: %#v", opts)
+	}
+	c.Platforms = append(c.Platforms,
+		&PlatformConfig{Name: "linux/arm64", Cgo: true, CC: "zig cc -target aarch64-linux-gnu"})
+	opts = &gox.CompileOpts{
+		Platform: gox.Platform{OS: "linux", Arch: "arm64"},
+	}
+	c.Override(opts)
+	if !opts.Cgo || opts.CgoOpts.CC != "zig cc -target aarch64-linux-gnu" {
+		t.Fatalf("bad: %#v", opts)
+	}
+
+	c.Platforms = append(c.Platforms,
+		&PlatformConfig{Name: "linux/arm", Ldflags: "-s", Tags: "arm"},
+		&PlatformConfig{Name: "linux/arm/7", Tags: "armv7"})
+	opts = &gox.CompileOpts{
+		Platform: gox.Platform{OS: "linux", Arch: "arm", Variant: "7"},
+	}
+	c.Override(opts)
+	if opts.Ldflags != "-s" || opts.Tags != "armv7" {
+		t.Fatalf("bad: %#v", opts)
+	}
+}
+
+func TestConfigApplyFlags_linkVars(t *testing.T) {
+	path := testConfig(t, "gox.hcl", `
+x = ["main.version={{.Version}}", "main.name=Hello World"]
+
+platform "windows/amd64" {
+  x = ["main.gui=true"]
+}
+`)
+
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var vars []string
+	flags := flag.NewFlagSet("gox", flag.ContinueOnError)
+	flags.Var((*linkVarValue)(&vars), "X", "")
+	if err := c.ApplyFlags(flags); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []string{"main.version={{.Version}}", "main.name=Hello World"}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Fatalf("bad: %#v", vars)
+	}
+
+	opts := &gox.CompileOpts{
+		Platform: gox.Platform{OS: "windows", Arch: "amd64"},
+		LinkVars: vars,
+	}
+	c.Override(opts)
+	expected = append(expected, "main.gui=true")
+	if !reflect.DeepEqual(opts.LinkVars, expected) {
+		t.Fatalf("bad: %#v", opts.LinkVars)
+	}
+
+	// Overriding one platform doesn't change the variables of another
+	// that share them
+	c.Platforms = append(c.Platforms, &PlatformConfig{Name: "linux/amd64", X: []string{"main.gui=false"}})
+	shared := append(make([]string, 0, 4), vars...)
+	windows := &gox.CompileOpts{Platform: gox.Platform{OS: "windows", Arch: "amd64"}, LinkVars: shared}
+	linux := &gox.CompileOpts{Platform: gox.Platform{OS: "linux", Arch: "amd64"}, LinkVars: shared}
+	c.Override(windows)
+	c.Override(linux)
+	if windows.LinkVars[2] != "main.gui=true" || linux.LinkVars[2] != "main.gui=false" {
+		t.Fatalf("bad: %#v %#v", windows.LinkVars, linux.LinkVars)
+	}
+
+	// The command-line replaces the config
+	vars = nil
+	flags = flag.NewFlagSet("gox", flag.ContinueOnError)
+	flags.Var((*linkVarValue)(&vars), "X", "")
+	if err := flags.Parse([]string{"-X", "main.version=dev"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := c.ApplyFlags(flags); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(vars, []string{"main.version=dev"}) {
+		t.Fatalf("bad: %#v", vars)
+	}
+}
+
+func TestConfigOverride_cliFlags(t *testing.T) {
+	path := testConfig(t, "gox.hcl", `
+platform "linux/amd64" {
+  ldflags = "-s -w"
+  output  = "cfg/{{.Dir}}"
+  tags    = "netgo"
+  x       = ["main.platform=linux"]
+}
+`)
+
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var ldflags, output, tags string
+	var vars []string
+	flags := flag.NewFlagSet("gox", flag.ContinueOnError)
+	flags.StringVar(&ldflags, "ldflags", "", "")
+	flags.StringVar(&output, "output", "", "")
+	flags.StringVar(&tags, "tags", "", "")
+	flags.Var((*linkVarValue)(&vars), "X", "")
+	args := []string{"-ldflags", "-X main.cli=1", "-output", "cli/{{.Dir}}", "-X", "main.v=1"}
+	if err := flags.Parse(args); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := c.ApplyFlags(flags); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	opts := &gox.CompileOpts{
+		Platform:  gox.Platform{OS: "linux", Arch: "amd64"},
+		OutputTpl: output,
+		Ldflags:   ldflags,
+		Tags:      tags,
+		LinkVars:  vars,
+	}
+	c.Override(opts)
+	if opts.Ldflags != "-X main.cli=1" || opts.OutputTpl != "cli/{{.Dir}}" {
+		t.Fatalf("command-line should win: %#v", opts)
+	}
+	if !reflect.DeepEqual(opts.LinkVars, []string{"main.v=1"}) {
+		t.Fatalf("command-line should win: %#v", opts.LinkVars)
+	}
+
+	// Settings that weren't given on the command-line still apply
+	if opts.Tags != "netgo" {
+		t.Fatalf("bad: %#v", opts)
+	}
+}
+
+func TestRelTemplate(t *testing.T) {
+	dir := filepath.Join("src", "foo")
+	abs, err := filepath.Abs(filepath.Join("dist", "foo"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cases := []struct {
+		Tpl      string
+		Expected string
+	}{
+		{"", ""},
+		{"dist/{{.OS}}", dir + string(filepath.Separator) + "dist/{{.OS}}"},
+		{"{{.Dir}}/../{{.OS}}", dir + string(filepath.Separator) + "{{.Dir}}/../{{.OS}}"},
+		{abs, abs},
+	}
+
+	for _, tc := range cases {
+		if actual := relTemplate(dir, tc.Tpl); actual != tc.Expected {
+			t.Fatalf("%q: bad: %s", tc.Tpl, actual)
+		}
+	}
+}
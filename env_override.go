@@ -3,15 +3,163 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/mitchellh/gox/gox"
 )
 
+// envOverrides applies every GOX_* environment variable override for the
+// platform of opts.
+func envOverrides(opts *gox.CompileOpts) error {
+	p := opts.Platform
+	envOverrideList(&opts.Ldflags, p, "LDFLAGS", " ")
+	envOverrideList(&opts.Gcflags, p, "GCFLAGS", " ")
+	envOverrideList(&opts.Asmflags, p, "ASMFLAGS", " ")
+	envOverrideList(&opts.Tags, p, "TAGS", ",")
+	envOverride(&opts.ModMode, p, "MOD")
+	envOverride(&opts.BuildMode, p, "BUILDMODE")
+	envOverride(&opts.CgoOpts.CC, p, "CC")
+	envOverride(&opts.CgoOpts.CXX, p, "CXX")
+	envOverrideList(&opts.CgoOpts.CFlags, p, "CGO_CFLAGS", " ")
+	envOverrideList(&opts.CgoOpts.CXXFlags, p, "CGO_CXXFLAGS", " ")
+	envOverrideList(&opts.CgoOpts.LDFlags, p, "CGO_LDFLAGS", " ")
+	envOverride(&opts.CgoOpts.PkgConfigPath, p, "PKG_CONFIG_PATH")
+	envOverride(&opts.CgoOpts.Sysroot, p, "SYSROOT")
+	envOverrideEnv(&opts.Env, p)
+
+	if err := envOverrideBool(&opts.Trimpath, p, "TRIMPATH"); err != nil {
+		return err
+	}
+
+	// Cgo is enabled by default for the host platform, so disabling it
+	// has to be explicit.
+	var cgo string
+	envOverride(&cgo, p, "CGO")
+	if err := envOverrideBool(&opts.Cgo, p, "CGO"); err != nil {
+		return err
+	}
+	if cgo != "" && !opts.Cgo {
+		opts.Env = append(append([]string(nil), opts.Env...), "CGO_ENABLED=0")
+	}
+
+	return envOverrideDuration(&opts.Timeout, p, "TIMEOUT")
+}
+
+// envNames returns the names of the env vars that override key for the
+// platform, from the least to the most specific: GOX_{OS}_{KEY},
+// GOX_{ARCH}_{KEY}, GOX_{OS}_{ARCH}_{KEY} and, if the platform has a
+// variant, GOX_{OS}_{ARCH}_{VARIANT}_{KEY}.
+func envNames(platform gox.Platform, key string) []string {
+	levels := []string{
+		platform.OS,
+		platform.Arch,
+		platform.OS + "_" + platform.Arch,
+	}
+	if platform.Variant != "" {
+		levels = append(levels, platform.OS+"_"+platform.Arch+"_"+platform.Variant)
+	}
+
+	result := make([]string, len(levels))
+	for i, level := range levels {
+		result[i] = strings.ToUpper(fmt.Sprintf("GOX_%s_%s", level, key))
+	}
+
+	return result
+}
+
 // envOverride overrides the given target based on if there is a
-// env var in the format of GOX_{OS}_{ARCH}_{KEY}.
-func envOverride(target *string, platform Platform, key string) {
-	key = strings.ToUpper(fmt.Sprintf(
-		"GOX_%s_%s_%s", platform.OS, platform.Arch, key))
-	if v := os.Getenv(key); v != "" {
-		*target = v
+// env var in the format of GOX_{OS}_{ARCH}_{KEY}, or one of the other
+// levels in envNames. The most specific one wins.
+func envOverride(target *string, platform gox.Platform, key string) {
+	for _, name := range envNames(platform, key) {
+		if v := os.Getenv(name); v != "" {
+			*target = v
+		}
+	}
+}
+
+// envOverrideList is like envOverride, but each level may also have an
+// env var ending in _APPEND, whose value is added to the value so far
+// with sep rather than replacing it.
+func envOverrideList(target *string, platform gox.Platform, key string, sep string) {
+	for _, name := range envNames(platform, key) {
+		if v := os.Getenv(name); v != "" {
+			*target = v
+		}
+		if v := os.Getenv(name + "_APPEND"); v != "" {
+			if *target != "" {
+				*target += sep
+			}
+			*target += v
+		}
+	}
+}
+
+// envOverrideBool is like envOverride, but for booleans. An error is
+// returned if the env var isn't a valid boolean.
+func envOverrideBool(target *bool, platform gox.Platform, key string) error {
+	for _, name := range envNames(platform, key) {
+		v := os.Getenv(name)
+		if v == "" {
+			continue
+		}
+
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+
+		*target = b
+	}
+
+	return nil
+}
+
+// envOverrideDuration is like envOverride, but for durations. An error is
+// returned if the env var isn't a valid duration.
+func envOverrideDuration(target *time.Duration, platform gox.Platform, key string) error {
+	for _, name := range envNames(platform, key) {
+		v := os.Getenv(name)
+		if v == "" {
+			continue
+		}
+
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+
+		*target = d
+	}
+
+	return nil
+}
+
+// envOverrideEnv adds the variables from every env var named like
+// GOX_{OS}_{ARCH}_ENV_{NAME}, or one of the other levels in envNames, to
+// env as NAME=value. These come after any that are already in env, and
+// the more specific levels come last so that they take precedence. The
+// env may be shared with other builds, so it is copied rather than
+// appended to.
+func envOverrideEnv(env *[]string, platform gox.Platform) {
+	var vars []string
+	environ := os.Environ()
+	for _, prefix := range envNames(platform, "ENV_") {
+		for _, kv := range environ {
+			if !strings.HasPrefix(kv, prefix) {
+				continue
+			}
+
+			// Skip values without a name, like GOX_LINUX_ENV_=foo
+			if kv = kv[len(prefix):]; strings.Index(kv, "=") > 0 {
+				vars = append(vars, kv)
+			}
+		}
+	}
+
+	if len(vars) > 0 {
+		*env = append(append([]string(nil), *env...), vars...)
 	}
 }
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/gox/gox"
+)
+
+func TestEnvOverride(t *testing.T) {
+	defer os.Unsetenv("GOX_LINUX_ARM_LDFLAGS")
+	os.Setenv("GOX_LINUX_ARM_LDFLAGS", "-s")
+
+	v := "-w"
+	envOverride(&v, gox.Platform{OS: "linux", Arch: "amd64"}, "LDFLAGS")
+	if v != "-w" {
+		t.Fatalf("bad: %s", v)
+	}
+
+	envOverride(&v, gox.Platform{OS: "linux", Arch: "arm"}, "LDFLAGS")
+	if v != "-s" {
+		t.Fatalf("bad: %s", v)
+	}
+}
+
+func TestEnvOverrideDuration(t *testing.T) {
+	defer os.Unsetenv("GOX_LINUX_ARM_TIMEOUT")
+	os.Setenv("GOX_LINUX_ARM_TIMEOUT", "10m")
+
+	d := time.Minute
+	if err := envOverrideDuration(&d, gox.Platform{OS: "linux", Arch: "arm"}, "TIMEOUT"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if d != 10*time.Minute {
+		t.Fatalf("bad: %s", d)
+	}
+
+	os.Setenv("GOX_LINUX_ARM_TIMEOUT", "soon")
+	if err := envOverrideDuration(&d, gox.Platform{OS: "linux", Arch: "arm"}, "TIMEOUT"); err == nil {
+		t.Fatal("should err")
+	}
+}
+
+func TestEnvOverride_levels(t *testing.T) {
+	defer os.Unsetenv("GOX_LINUX_LDFLAGS")
+	defer os.Unsetenv("GOX_ARM_LDFLAGS")
+	defer os.Unsetenv("GOX_LINUX_ARM_7_LDFLAGS")
+	os.Setenv("GOX_LINUX_LDFLAGS", "-a")
+	os.Setenv("GOX_ARM_LDFLAGS", "-b")
+	os.Setenv("GOX_LINUX_ARM_7_LDFLAGS", "-c")
+
+	cases := []struct {
+		Platform gox.Platform
+		Expected string
+	}{
+		{gox.Platform{OS: "darwin", Arch: "amd64"}, ""},
+		{gox.Platform{OS: "linux", Arch: "amd64"}, "-a"},
+		{gox.Platform{OS: "freebsd", Arch: "arm"}, "-b"},
+		{gox.Platform{OS: "linux", Arch: "arm"}, "-b"},
+		{gox.Platform{OS: "linux", Arch: "arm", Variant: "6"}, "-b"},
+		{gox.Platform{OS: "linux", Arch: "arm", Variant: "7"}, "-c"},
+	}
+
+	for _, tc := range cases {
+		var v string
+		envOverride(&v, tc.Platform, "LDFLAGS")
+		if v != tc.Expected {
+			t.Fatalf("%#v bad: %s", tc.Platform, v)
+		}
+	}
+}
+
+func TestEnvOverrideList(t *testing.T) {
+	defer os.Unsetenv("GOX_WINDOWS_TAGS_APPEND")
+	defer os.Unsetenv("GOX_WINDOWS_386_TAGS")
+	defer os.Unsetenv("GOX_WINDOWS_386_TAGS_APPEND")
+	os.Setenv("GOX_WINDOWS_TAGS_APPEND", "gui")
+
+	v := "netgo"
+	envOverrideList(&v, gox.Platform{OS: "windows", Arch: "amd64"}, "TAGS", ",")
+	if v != "netgo,gui" {
+		t.Fatalf("bad: %s", v)
+	}
+
+	os.Setenv("GOX_WINDOWS_386_TAGS", "legacy")
+	os.Setenv("GOX_WINDOWS_386_TAGS_APPEND", "small")
+	v = "netgo"
+	envOverrideList(&v, gox.Platform{OS: "windows", Arch: "386"}, "TAGS", ",")
+	if v != "legacy,small" {
+		t.Fatalf("bad: %s", v)
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+	vars := map[string]string{
+		"GOX_LINUX_CGO":           "0",
+		"GOX_LINUX_ARM_TRIMPATH":  "true",
+		"GOX_LINUX_ARM_MOD":       "vendor",
+		"GOX_LINUX_ARM_ENV_GOARM": "6",
+		"GOX_ARM_ENV_FOO":         "bar",
+	}
+	for k, v := range vars {
+		defer os.Unsetenv(k)
+		os.Setenv(k, v)
+	}
+
+	// The env has spare capacity and is shared with another build
+	shared := make([]string, 0, 8)
+	opts := &gox.CompileOpts{
+		Platform: gox.Platform{OS: "linux", Arch: "arm"},
+		Cgo:      true,
+		Env:      shared,
+	}
+	if err := envOverrides(opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if other := shared[:3]; other[0] != "" {
+		t.Fatalf("shared env was changed: %#v", other)
+	}
+
+	if opts.Cgo || !opts.Trimpath || opts.ModMode != "vendor" {
+		t.Fatalf("bad: %#v", opts)
+	}
+
+	expected := []string{"FOO=bar", "GOARM=6", "CGO_ENABLED=0"}
+	if !reflect.DeepEqual(opts.Env, expected) {
+		t.Fatalf("bad: %#v", opts.Env)
+	}
+
+	os.Setenv("GOX_LINUX_ARM_TRIMPATH", "maybe")
+	if err := envOverrides(opts); err == nil {
+		t.Fatal("should err")
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/mitchellh/gox/gox"
+)
+
+// appendListValue is a flag.Value that appends values to the list, where
+// the values come from space-separated lines. Unlike the -os and -arch
+// flags, the case of the values is preserved, so this can be used for paths.
+type appendListValue []string
+
+func (s *appendListValue) String() string {
+	return strings.Join(*s, " ")
+}
+
+func (s *appendListValue) Set(value string) error {
+	for _, v := range strings.Fields(value) {
+		*s = append(*s, v)
+	}
+
+	return nil
+}
+
+// linkVarValue is a flag.Value for the -X flag, which may be given more
+// than once. Each value is a single importpath.name=value, which isn't
+// split on spaces so that values can contain them.
+type linkVarValue []string
+
+func (s *linkVarValue) String() string {
+	return strings.Join(*s, " ")
+}
+
+func (s *linkVarValue) Set(value string) error {
+	if err := gox.ValidateLinkVar(value); err != nil {
+		return err
+	}
+
+	*s = append(*s, value)
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestAppendListValue_impl(t *testing.T) {
+	var _ flag.Value = new(appendListValue)
+}
+
+func TestAppendListValue(t *testing.T) {
+	var value appendListValue
+
+	if err := value.Set(""); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(value) > 0 {
+		t.Fatalf("bad: %#v", value)
+	}
+
+	if err := value.Set("LICENSE  README.md"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := value.Set("docs/*.md"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []string{"LICENSE", "README.md", "docs/*.md"}
+	if !reflect.DeepEqual([]string(value), expected) {
+		t.Fatalf("bad: %#v", value)
+	}
+}
+
+func TestLinkVarValue(t *testing.T) {
+	var value linkVarValue
+
+	if err := value.Set("main.version={{.Version}}"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := value.Set("main.name=Hello World"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []string{"main.version={{.Version}}", "main.name=Hello World"}
+	if !reflect.DeepEqual([]string(value), expected) {
+		t.Fatalf("bad: %#v", value)
+	}
+
+	for _, v := range []string{"", "main.version", "=foo", "main.version={{.Version"} {
+		if err := value.Set(v); err == nil {
+			t.Fatalf("%q: should err", v)
+		}
+	}
+}
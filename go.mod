@@ -4,5 +4,9 @@ go 1.17
 
 require (
 	github.com/hashicorp/go-version v1.0.0
+	github.com/hashicorp/hcl v1.0.0
 	github.com/mitchellh/iochan v1.0.0
+	golang.org/x/crypto v0.9.0
 )
+
+require golang.org/x/sys v0.8.0 // indirect
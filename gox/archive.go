@@ -0,0 +1,227 @@
+package gox
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Archive formats that are supported by -archive-format.
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+)
+
+// archiveModTime is the modification time of every file in an archive,
+// so that the archive is reproducible byte-for-byte. This is the earliest
+// time that can be represented in a zip file.
+var archiveModTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type ArchiveOpts struct {
+	PackagePath string
+	Platform    Platform
+	BinaryPath  string
+	OutputTpl   string
+	Format      string
+	Files       []string
+
+	// TemplateData is the data for OutputTpl. If nil, only the fields
+	// that don't need to be looked up are set.
+	TemplateData *OutputTemplateData
+}
+
+// archiveFile is a single file to add to an archive.
+type archiveFile struct {
+	Path string
+	Name string
+	Mode int64
+}
+
+// GoArchive packages the binary built for the given options, along with
+// any extra files, into an archive. The path to the archive is returned.
+func GoArchive(opts *ArchiveOpts) (string, error) {
+	format, err := archiveFormat(opts)
+	if err != nil {
+		return "", err
+	}
+
+	outputPathReal, err := archivePath(opts, format)
+	if err != nil {
+		return "", err
+	}
+
+	// The binary always comes first, followed by the extra files in the
+	// order they were given so that the archive is always the same.
+	files := []archiveFile{{
+		Path: opts.BinaryPath,
+		Name: filepath.Base(opts.BinaryPath),
+		Mode: 0755,
+	}}
+	for _, pattern := range opts.Files {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return "", err
+		}
+		if len(matches) == 0 {
+			return "", fmt.Errorf("archive file not found: %s", pattern)
+		}
+
+		for _, match := range matches {
+			files = append(files, archiveFile{
+				Path: match,
+				Name: filepath.Base(match),
+				Mode: 0644,
+			})
+		}
+	}
+
+	// Like the binary, the archive is written to a temporary directory
+	// next to it and moved into place once it is complete, so that a
+	// failed or interrupted run keeps the previous archive.
+	outputDir := filepath.Dir(outputPathReal)
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return "", err
+	}
+	tempDir, err := newTempDir(outputDir, ".gox-")
+	if err != nil {
+		return "", err
+	}
+	defer removeTempDir(tempDir)
+	tempPath := filepath.Join(tempDir, filepath.Base(outputPathReal))
+
+	f, err := os.Create(tempPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	switch format {
+	case ArchiveZip:
+		err = writeZip(f, files)
+	case ArchiveTarGz:
+		err = writeTarGz(f, files)
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tempPath, outputPathReal); err != nil {
+		return "", err
+	}
+
+	return outputPathReal, nil
+}
+
+func writeZip(w io.Writer, files []archiveFile) error {
+	zw := zip.NewWriter(w)
+	for _, file := range files {
+		header := &zip.FileHeader{
+			Name:     file.Name,
+			Method:   zip.Deflate,
+			Modified: archiveModTime,
+		}
+		header.SetMode(os.FileMode(file.Mode))
+
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if err := copyFile(fw, file.Path); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func writeTarGz(w io.Writer, files []archiveFile) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, file := range files {
+		info, err := os.Stat(file.Path)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s: not a regular file", file.Path)
+		}
+
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     file.Name,
+			Mode:     file.Mode,
+			Size:     info.Size(),
+			ModTime:  archiveModTime,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if err := copyFile(tw, file.Path); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return gw.Close()
+}
+
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// archiveFormat returns the format of the archive, which defaults to zip
+// for Windows and tar.gz for everything else.
+func archiveFormat(opts *ArchiveOpts) (string, error) {
+	format := opts.Format
+	if format == "" {
+		format = ArchiveTarGz
+		if opts.Platform.OS == "windows" {
+			format = ArchiveZip
+		}
+	}
+	if format != ArchiveZip && format != ArchiveTarGz {
+		return "", fmt.Errorf("unknown archive format: %s", format)
+	}
+
+	return format, nil
+}
+
+// archivePath renders the output template of the options and returns the
+// absolute path to the archive in the given format.
+func archivePath(opts *ArchiveOpts, format string) (string, error) {
+	tplData := opts.TemplateData
+	if tplData == nil {
+		tplData = &OutputTemplateData{
+			Dir:     filepath.Base(opts.PackagePath),
+			OS:      opts.Platform.OS,
+			Arch:    opts.Platform.Arch,
+			Variant: opts.Platform.Variant,
+			Ext:     binaryExt(opts.Platform, ""),
+			Date:    time.Now().UTC(),
+		}
+	}
+	path, err := renderTemplate(opts.OutputTpl, tplData)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Abs(path + "." + format)
+}
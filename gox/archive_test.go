@@ -0,0 +1,164 @@
+package gox
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testArchive(t *testing.T, platform Platform, format string) (string, string) {
+	td, err := ioutil.TempDir("", "gox")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(td) })
+
+	bin := filepath.Join(td, "foo")
+	if err := ioutil.WriteFile(bin, []byte("binary"), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	license := filepath.Join(td, "LICENSE")
+	if err := ioutil.WriteFile(license, []byte("license"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	path, err := GoArchive(&ArchiveOpts{
+		PackagePath: "github.com/mitchellh/foo",
+		Platform:    platform,
+		BinaryPath:  bin,
+		OutputTpl:   filepath.Join(td, "dist", "{{.Dir}}_{{.OS}}_{{.Arch}}"),
+		Format:      format,
+		Files:       []string{license},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return td, path
+}
+
+func TestGoArchive_tarGz(t *testing.T) {
+	td, path := testArchive(t, Platform{OS: "linux", Arch: "amd64"}, "")
+	if path != filepath.Join(td, "dist", "foo_linux_amd64.tar.gz") {
+		t.Fatalf("bad: %s", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var names []string
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !header.ModTime.Equal(archiveModTime) {
+			t.Fatalf("bad mod time: %s", header.ModTime)
+		}
+
+		names = append(names, header.Name)
+	}
+
+	expected := []string{"foo", "LICENSE"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("bad: %#v", names)
+	}
+}
+
+func TestGoArchive_zip(t *testing.T) {
+	td, path := testArchive(t, Platform{OS: "windows", Arch: "amd64"}, "")
+	if path != filepath.Join(td, "dist", "foo_windows_amd64.zip") {
+		t.Fatalf("bad: %s", path)
+	}
+
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer zr.Close()
+
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+
+	expected := []string{"foo", "LICENSE"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("bad: %#v", names)
+	}
+	if zr.File[0].Mode().Perm() != 0755 {
+		t.Fatalf("bad mode: %s", zr.File[0].Mode())
+	}
+}
+
+func TestGoArchive_reproducible(t *testing.T) {
+	for _, format := range []string{ArchiveZip, ArchiveTarGz} {
+		_, path1 := testArchive(t, Platform{OS: "linux", Arch: "amd64"}, format)
+		_, path2 := testArchive(t, Platform{OS: "linux", Arch: "amd64"}, format)
+
+		data1, err := ioutil.ReadFile(path1)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		data2, err := ioutil.ReadFile(path2)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if !bytes.Equal(data1, data2) {
+			t.Fatalf("%s archives differ", format)
+		}
+	}
+}
+
+func TestGoArchive_keepsArchiveOnFailure(t *testing.T) {
+	td, path := testArchive(t, Platform{OS: "linux", Arch: "amd64"}, "")
+	before, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	_, err = GoArchive(&ArchiveOpts{
+		PackagePath: "github.com/mitchellh/foo",
+		Platform:    Platform{OS: "linux", Arch: "amd64"},
+		BinaryPath:  filepath.Join(td, "missing"),
+		OutputTpl:   filepath.Join(td, "dist", "{{.Dir}}_{{.OS}}_{{.Arch}}"),
+	})
+	if err == nil {
+		t.Fatal("should err")
+	}
+
+	after, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatal("previous archive should be kept")
+	}
+
+	entries, err := ioutil.ReadDir(filepath.Join(td, "dist"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("temp dir should be removed: %#v", entries)
+	}
+}
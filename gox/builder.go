@@ -0,0 +1,616 @@
+// Package gox cross-compiles Go packages for many platforms in parallel.
+// It is the library behind the gox command, and can be used by tools
+// that want to drive builds directly rather than run gox and parse its
+// output.
+package gox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Builder builds every package for every platform, running builds in
+// parallel. The zero value is ready to use once Packages and Platforms
+// are set: it runs one build at a time with the go command on the PATH,
+// and writes the binaries to the current directory.
+type Builder struct {
+	// Packages are the import paths of the main packages to build, such
+	// as those returned by GoMainDirs.
+	Packages []string
+
+	// Platforms are the platforms to build each package for, such as
+	// those returned by PlatformFlag.Platforms.
+	Platforms []Platform
+
+	// PackagePlatforms, if set, are the platforms to build some of the
+	// packages for instead of Platforms, such as those chosen with
+	// Directives.FilterPlatforms.
+	PackagePlatforms map[string][]Platform
+
+	// Parallel is the number of builds to run at once. Defaults to 1.
+	Parallel int
+
+	// FailFast, if true, cancels all remaining builds as soon as one
+	// fails. Otherwise every build runs to completion.
+	FailFast bool
+
+	// Opts are the options that every build starts from. The PackagePath
+	// and Platform are set for each build. If Date isn't set, it is set
+	// with SourceDate when Build is called so that it's the same for
+	// every build. GoCmd defaults to "go", and OutputTpl to
+	// DefaultOutputTemplate.
+	Opts CompileOpts
+
+	// Override, if set, is called with the options for each build before
+	// it starts so that per-platform settings can be applied. If it
+	// returns an error, the build fails with that error.
+	Override func(opts *CompileOpts) error
+
+	// Archive, if set, packages every binary after it is built. The
+	// PackagePath, Platform and BinaryPath are set for each build.
+	Archive *ArchiveOpts
+
+	// Checksums are the algorithms to compute the digests of every binary
+	// and archive with. See ChecksumFile.
+	Checksums []string
+
+	// Cache, if set, is used to skip building packages whose outputs are
+	// already up to date. See GoCrossCompileCached. The caller should
+	// Save it once the builds are done.
+	Cache *BuildCache
+
+	// VerifyReproducible, if true, builds every package twice more in
+	// isolation with VerifyReproducible and fails the build if those
+	// outputs differ.
+	// This is usually combined with Opts.Reproducible.
+	VerifyReproducible bool
+
+	// SkipUnsupported, if true, skips the platforms that don't support the
+	// build mode in the options, rather than failing them.
+	SkipUnsupported bool
+
+	// SkipExcluded, if true, skips the platforms that the build
+	// constraints of a package exclude, as found by GoPackageExcluded,
+	// rather than failing them.
+	SkipExcluded bool
+
+	// Stdout, if set, receives a line as each build starts, as well as
+	// the output of builds in verbose mode.
+	Stdout io.Writer
+
+	// packageInfo caches LookupPackageInfo for each package, since it is
+	// the same for every platform.
+	packageInfoLock sync.Mutex
+	packageInfo     map[string]*PackageInfo
+}
+
+// Result is the outcome of building one package for one platform.
+type Result struct {
+	Package  string
+	Platform Platform
+
+	// Opts are the options the package was built with, after Override.
+	Opts CompileOpts
+
+	// Ldflags are the -ldflags go build was given, which add the link
+	// variables and the flags for reproducible builds to those in Opts.
+	// They are set once the build command has been worked out.
+	Ldflags string
+
+	// Output and Archive are the paths to the binary and the archive,
+	// set once each of them has been built. Header is the path to the C
+	// header for the c-archive and c-shared build modes.
+	Output    string
+	Header    string
+	Archive   string
+	Checksums []*Checksum
+
+	Duration time.Duration
+
+	// Err is the reason the build failed, or nil if it succeeded.
+	Err error
+
+	// Cancelled is true if the build was cancelled, either before it
+	// started or while it was running, because another build failed with
+	// FailFast or the context was cancelled.
+	Cancelled bool
+
+	// Skipped is true if the platform doesn't support the build mode and
+	// SkipUnsupported is set, or the package can't be built for it and
+	// SkipExcluded is set. SkipReason says which. Skipped builds aren't
+	// errors.
+	Skipped    bool
+	SkipReason string
+
+	// Cached is true if the output was already up to date, so go build
+	// wasn't run.
+	Cached bool
+}
+
+// TimedOut returns true if the build failed because it ran longer than
+// the timeout in its options.
+func (r *Result) TimedOut() bool {
+	_, ok := r.Err.(*TimeoutError)
+	return ok
+}
+
+// BuildError is the error returned by Builder.Build if any of the builds
+// failed, timed out or were cancelled. Each of those results appears in
+// exactly one of the lists. Skipped builds aren't errors.
+type BuildError struct {
+	Failed    []*Result
+	TimedOut  []*Result
+	Cancelled []*Result
+}
+
+func (e *BuildError) Error() string {
+	return fmt.Sprintf("%d builds failed, %d timed out, %d cancelled",
+		len(e.Failed), len(e.TimedOut), len(e.Cancelled))
+}
+
+// CheckError is the error returned by Builder.Check, listing every
+// problem that was found.
+type CheckError struct {
+	Errors []error
+}
+
+func (e *CheckError) Error() string {
+	lines := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		lines[i] = err.Error()
+	}
+
+	return fmt.Sprintf("%d problem(s) found before building:\n%s",
+		len(e.Errors), strings.Join(lines, "\n"))
+}
+
+// OutputCollisionError is a problem found by Builder.Check when more than
+// one build would write to the same path, so that all but the last
+// output would be lost.
+type OutputCollisionError struct {
+	Path string
+
+	// Jobs are the builds that would write to Path, each as
+	// "platform: package".
+	Jobs []string
+}
+
+func (e *OutputCollisionError) Error() string {
+	return fmt.Sprintf("%d builds would write to %s: %s",
+		len(e.Jobs), e.Path, strings.Join(e.Jobs, ", "))
+}
+
+// Check looks for problems that would make builds fail, without running
+// them, so that they can be reported before anything is built. Currently
+// this checks that there is a C compiler for every platform that has cgo
+// enabled, that the output and archive templates can be rendered, and
+// that no two builds would write to the same path. Each problem is only
+// reported once, even if it affects many builds. If there are any
+// problems then the error is a *CheckError. Jobs are checked with the
+// same options they are built with, and jobs that would be skipped
+// aren't checked, which may run go list to find the excluded packages.
+// Build calls Check before building anything.
+func (b *Builder) Check(ctx context.Context) error {
+	opts, err := b.baseOpts()
+	if err != nil {
+		return err
+	}
+
+	_, err = b.check(ctx, opts)
+	return err
+}
+
+// check is Check with the base options for every job. It also returns
+// why each job that would be skipped is skipped, so that Build doesn't
+// have to work that out again.
+func (b *Builder) check(ctx context.Context, base CompileOpts) (map[job]string, error) {
+	var errs []error
+	seen := make(map[string]struct{})
+	addErr := func(err error) {
+		if _, ok := seen[err.Error()]; !ok {
+			seen[err.Error()] = struct{}{}
+			errs = append(errs, err)
+		}
+	}
+
+	// Options that can't be worked out are left nil, since the error is
+	// reported when the build runs.
+	jobs := b.jobs()
+	jobOpts := make([]*CompileOpts, len(jobs))
+	for i, job := range jobs {
+		if opts, err := b.jobOpts(base, job.path, job.platform); err == nil {
+			jobOpts[i] = &opts
+		}
+	}
+
+	skips, err := b.skipReasons(ctx, jobs, jobOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := make(map[string][]string)
+	for i, job := range jobs {
+		opts := jobOpts[i]
+		if opts == nil || skips[job] != "" {
+			continue
+		}
+
+		if err := ResolveCgo(opts); err != nil {
+			addErr(err)
+		}
+
+		data := NewOutputTemplateData(opts)
+
+		name := fmt.Sprintf("%s: %s", job.platform.String(), job.path)
+		path, err := outputPath(opts, data)
+		if err != nil {
+			addErr(fmt.Errorf("invalid output template: %s", err))
+			continue
+		}
+		outputs[path] = append(outputs[path], name)
+
+		if b.Archive != nil {
+			archive := *b.Archive
+			archive.PackagePath = job.path
+			archive.Platform = job.platform
+			archive.TemplateData = data
+			format, err := archiveFormat(&archive)
+			if err != nil {
+				addErr(err)
+				continue
+			}
+			path, err := archivePath(&archive, format)
+			if err != nil {
+				addErr(fmt.Errorf("invalid archive output template: %s", err))
+				continue
+			}
+			outputs[path] = append(outputs[path], name)
+		}
+	}
+
+	paths := make([]string, 0, len(outputs))
+	for path, jobs := range outputs {
+		if len(jobs) > 1 {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		errs = append(errs, &OutputCollisionError{Path: path, Jobs: outputs[path]})
+	}
+
+	if len(errs) > 0 {
+		return nil, &CheckError{Errors: errs}
+	}
+
+	return skips, nil
+}
+
+// skipReasons works out why each of the jobs with options would be
+// skipped, if it would be. Each may run go list, so they run in parallel
+// like the builds do.
+func (b *Builder) skipReasons(ctx context.Context, jobs []job, jobOpts []*CompileOpts) (map[job]string, error) {
+	parallel := b.Parallel
+	if parallel <= 0 {
+		parallel = 1
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	skips := make(map[job]string)
+	semaphore := make(chan int, parallel)
+	for i := range jobs {
+		if jobOpts[i] == nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func(j job, opts *CompileOpts) {
+			defer wg.Done()
+			semaphore <- 1
+			defer func() { <-semaphore }()
+
+			if reason := b.skipReason(ctx, opts); reason != "" {
+				lock.Lock()
+				defer lock.Unlock()
+				skips[j] = reason
+			}
+		}(jobs[i], jobOpts[i])
+	}
+	wg.Wait()
+
+	return skips, ctx.Err()
+}
+
+// Build runs every build and returns the results, sorted by package and
+// then platform. Before anything is built, the builds are checked with
+// Check and its error is returned if there are any problems. If any of
+// the builds didn't succeed then the error is a *BuildError. Cancelling
+// the context cancels all of the builds.
+func (b *Builder) Build(ctx context.Context) ([]*Result, error) {
+	parallel := b.Parallel
+	if parallel <= 0 {
+		parallel = 1
+	}
+
+	opts, optsErr := b.baseOpts()
+	if optsErr != nil {
+		return nil, optsErr
+	}
+	skips, checkErr := b.check(ctx, opts)
+	if checkErr != nil {
+		return nil, checkErr
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var resultLock sync.Mutex
+	var wg sync.WaitGroup
+	jobs := b.jobs()
+	results := make([]*Result, 0, len(jobs))
+	semaphore := make(chan int, parallel)
+	for _, job := range jobs {
+		// Start the goroutine that will do the actual build
+		wg.Add(1)
+		go func(path string, platform Platform, skipReason string) {
+			defer wg.Done()
+			result := b.build(ctx, semaphore, opts, path, platform, skipReason)
+			if result.Err != nil && !result.Cancelled && b.FailFast {
+				cancel()
+			}
+
+			resultLock.Lock()
+			defer resultLock.Unlock()
+			results = append(results, result)
+		}(job.path, job.platform, skips[job])
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+
+		return a.Platform.String() < b.Platform.String()
+	})
+
+	var err BuildError
+	for _, result := range results {
+		switch {
+		case result.Cancelled:
+			err.Cancelled = append(err.Cancelled, result)
+		case result.TimedOut():
+			err.TimedOut = append(err.TimedOut, result)
+		case result.Err != nil:
+			err.Failed = append(err.Failed, result)
+		}
+	}
+	if len(err.Failed) > 0 || len(err.TimedOut) > 0 || len(err.Cancelled) > 0 {
+		return results, &err
+	}
+
+	return results, nil
+}
+
+// build builds a single package for a single platform once there is room
+// for it in the semaphore, followed by the archive and checksums. The
+// build is skipped if there is a skip reason, as found by check.
+func (b *Builder) build(ctx context.Context, semaphore chan int, opts CompileOpts, path string, platform Platform, skipReason string) *Result {
+	result := &Result{
+		Package:  path,
+		Platform: platform,
+		Opts:     opts,
+	}
+
+	select {
+	case semaphore <- 1:
+		defer func() { <-semaphore }()
+	case <-ctx.Done():
+		result.Cancelled = true
+		return result
+	}
+	if ctx.Err() != nil {
+		result.Cancelled = true
+		return result
+	}
+
+	start := time.Now()
+	defer func() { result.Duration = time.Since(start) }()
+
+	result.Opts, result.Err = b.jobOpts(opts, path, platform)
+	if result.Err != nil {
+		return result
+	}
+
+	if result.SkipReason = skipReason; result.SkipReason != "" {
+		result.Skipped = true
+		return result
+	}
+
+	// Resolve the C compiler here so that the result shows the one used
+	if result.Err = ResolveCgo(&result.Opts); result.Err != nil {
+		return result
+	}
+
+	if b.Stdout != nil {
+		printf(b.Stdout, "--> %15s: %s\n", platform.String(), path)
+	}
+
+	// GoCrossCompile modifies the options it is given, so give it a copy
+	// to keep the options in the result as they were.
+	opts = result.Opts
+	cmd, cached, err := goCrossCompileCached(ctx, &opts, b.Cache)
+	if cmd != nil {
+		result.Ldflags = cmd.Ldflags
+	}
+	if result.Err = err; result.Err != nil {
+		// If the context is done, we were killed rather than failing
+		result.Cancelled = ctx.Err() != nil
+		return result
+	}
+	result.Output, result.Cached = cmd.Output, cached
+	if header := headerPath(result.Output, result.Opts.BuildMode); header != "" {
+		if _, err := os.Stat(header); err == nil {
+			result.Header = header
+		}
+	}
+
+	if b.VerifyReproducible {
+		result.Err = VerifyReproducible(ctx, &result.Opts, result.Output)
+		if result.Err != nil {
+			result.Cancelled = ctx.Err() != nil
+			return result
+		}
+	}
+
+	artifacts := []string{result.Output}
+	if b.Archive != nil {
+		archive := *b.Archive
+		archive.PackagePath = path
+		archive.Platform = platform
+		archive.BinaryPath = result.Output
+		archive.TemplateData = NewOutputTemplateData(&result.Opts)
+		if result.Header != "" {
+			archive.Files = append([]string{result.Header}, archive.Files...)
+		}
+		result.Archive, result.Err = GoArchive(&archive)
+		if result.Err != nil {
+			return result
+		}
+
+		artifacts = append(artifacts, result.Archive)
+	}
+
+	// Hash the artifacts here rather than at the end so that hashing is
+	// parallelized along with the builds.
+	if len(b.Checksums) > 0 {
+		for _, artifact := range artifacts {
+			sum, err := ChecksumFile(artifact, b.Checksums)
+			if err != nil {
+				result.Err = err
+				return result
+			}
+
+			result.Checksums = append(result.Checksums, sum)
+		}
+	}
+
+	return result
+}
+
+// job is a single package to build for a single platform.
+type job struct {
+	path     string
+	platform Platform
+}
+
+// jobs returns every package and platform to build, using the platforms
+// in PackagePlatforms for the packages that are in it.
+func (b *Builder) jobs() []job {
+	var result []job
+	for _, path := range b.Packages {
+		platforms, ok := b.PackagePlatforms[path]
+		if !ok {
+			platforms = b.Platforms
+		}
+
+		for _, platform := range platforms {
+			result = append(result, job{path: path, platform: platform})
+		}
+	}
+
+	return result
+}
+
+// baseOpts returns the options that every build starts from, with the
+// defaults set for the GoCmd, OutputTpl and Date if they aren't already.
+func (b *Builder) baseOpts() (CompileOpts, error) {
+	opts := b.Opts
+	if opts.GoCmd == "" {
+		opts.GoCmd = "go"
+	}
+	if opts.OutputTpl == "" {
+		opts.OutputTpl = DefaultOutputTemplate
+	}
+	if opts.Date.IsZero() {
+		date, err := SourceDate()
+		if err != nil {
+			return opts, err
+		}
+
+		opts.Date = date
+	}
+
+	return opts, nil
+}
+
+// skipReason returns why the build with the given options is skipped
+// because of SkipUnsupported or SkipExcluded, or an empty string if it
+// isn't.
+func (b *Builder) skipReason(ctx context.Context, opts *CompileOpts) string {
+	if b.SkipUnsupported && !BuildModeSupported(opts.Platform, opts.BuildMode) {
+		return fmt.Sprintf("-buildmode=%s is not supported", opts.BuildMode)
+	}
+
+	// If go list fails then so will go build, which gives a better error,
+	// so the package is built as usual.
+	if b.SkipExcluded {
+		if excluded, err := GoPackageExcluded(ctx, opts); err == nil && excluded {
+			return "build constraints exclude all Go files"
+		}
+	}
+
+	return ""
+}
+
+// jobOpts returns the options for building a single package for a single
+// platform, with Override applied.
+func (b *Builder) jobOpts(opts CompileOpts, path string, platform Platform) (CompileOpts, error) {
+	opts.PackagePath = path
+	opts.Platform = platform
+
+	// Jobs run in parallel, so each gets its own copy of the slices for
+	// Override to change.
+	opts.LinkVars = append([]string(nil), opts.LinkVars...)
+	opts.Env = append([]string(nil), opts.Env...)
+	if opts.Stdout == nil {
+		opts.Stdout = b.Stdout
+	}
+
+	if b.Override != nil {
+		if err := b.Override(&opts); err != nil {
+			return opts, err
+		}
+	}
+
+	if opts.PackageInfo == nil {
+		opts.PackageInfo = b.lookupPackageInfo(opts.GoCmd, path)
+	}
+
+	return opts, nil
+}
+
+// lookupPackageInfo returns LookupPackageInfo for the package, only
+// looking it up the first time.
+func (b *Builder) lookupPackageInfo(GoCmd string, path string) *PackageInfo {
+	b.packageInfoLock.Lock()
+	defer b.packageInfoLock.Unlock()
+	if info, ok := b.packageInfo[path]; ok {
+		return info
+	}
+
+	if b.packageInfo == nil {
+		b.packageInfo = make(map[string]*PackageInfo)
+	}
+	info := LookupPackageInfo(GoCmd, path)
+	b.packageInfo[path] = info
+	return info
+}
@@ -0,0 +1,308 @@
+package gox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestBuilderBuild(t *testing.T) {
+	td := testModule(t, map[string]string{
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+
+	host := Platform{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	broken := Platform{OS: "plan9", Arch: "zzz"}
+	b := &Builder{
+		Packages:  []string{"_" + filepath.ToSlash(td)},
+		Platforms: []Platform{host, broken},
+		Parallel:  2,
+		Opts: CompileOpts{
+			OutputTpl:    filepath.Join(td, "dist", "{{.OS}}_{{.Arch}}"),
+			GoCmd:        "go",
+			LinkVars:     []string{"main.os={{.OS}}"},
+			Reproducible: true,
+		},
+		Checksums: []string{"sha256"},
+		Override: func(opts *CompileOpts) error {
+			if opts.Platform == broken {
+				return errors.New("broken")
+			}
+
+			opts.Ldflags = "-s"
+			return nil
+		},
+	}
+
+	results, err := b.Build(context.Background())
+	buildErr, ok := err.(*BuildError)
+	if !ok {
+		t.Fatalf("err: %s", err)
+	}
+	if len(buildErr.Failed) != 1 || buildErr.Failed[0].Platform != broken {
+		t.Fatalf("bad: %#v", buildErr)
+	}
+	if len(results) != 2 {
+		t.Fatalf("bad: %#v", results)
+	}
+
+	for _, result := range results {
+		if result.Platform != host {
+			continue
+		}
+
+		if result.Err != nil {
+			t.Fatalf("err: %s", result.Err)
+		}
+		if result.Opts.Ldflags != "-s" {
+			t.Fatalf("override not applied: %#v", result.Opts)
+		}
+		if expected := "-s -X main.os=" + host.OS + " -buildid="; result.Ldflags != expected {
+			t.Fatalf("bad ldflags: %q", result.Ldflags)
+		}
+		if _, err := os.Stat(result.Output); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if len(result.Checksums) != 1 {
+			t.Fatalf("bad: %#v", result.Checksums)
+		}
+	}
+}
+
+func TestBuilderBuild_zeroValue(t *testing.T) {
+	td := testModule(t, map[string]string{
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(td); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	host := Platform{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	b := &Builder{
+		Packages:  []string{"_" + filepath.ToSlash(td)},
+		Platforms: []Platform{host},
+	}
+	results, err := b.Build(context.Background())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := filepath.Base(td) + "_" + host.OS + "_" + host.Arch
+	if len(results) != 1 || filepath.Base(results[0].Output) != expected {
+		t.Fatalf("bad: %#v", results)
+	}
+	if _, err := os.Stat(filepath.Join(td, expected)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBuilderBuild_failFast(t *testing.T) {
+	td := testModule(t, map[string]string{
+		"main.go": "package main\n\nfunc main() {}\n",
+
+		// Stands in for a slow go build, so that the other builds are
+		// still running when one fails
+		"slowgo": "#!/bin/sh\nif [ \"$1\" = build ]; then sleep 60; fi\nexec go \"$@\"\n",
+	})
+	slowGo := filepath.Join(td, "slowgo")
+	if err := os.Chmod(slowGo, 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	broken := Platform{OS: "linux", Arch: "386"}
+	b := &Builder{
+		Packages: []string{"_" + filepath.ToSlash(td)},
+		Platforms: []Platform{
+			{OS: "linux", Arch: "amd64"},
+			broken,
+			{OS: "linux", Arch: "arm64"},
+		},
+		Parallel: 3,
+		FailFast: true,
+		Opts: CompileOpts{
+			OutputTpl: filepath.Join(td, "dist", "{{.OS}}_{{.Arch}}"),
+			GoCmd:     "go",
+		},
+		Override: func(opts *CompileOpts) error {
+			if opts.Platform == broken {
+				return errors.New("broken")
+			}
+
+			opts.GoCmd = slowGo
+			return nil
+		},
+	}
+
+	_, err := b.Build(context.Background())
+	buildErr, ok := err.(*BuildError)
+	if !ok {
+		t.Fatalf("err: %s", err)
+	}
+	if len(buildErr.Failed) != 1 || buildErr.Failed[0].Platform != broken {
+		t.Fatalf("bad: %#v", buildErr.Failed)
+	}
+	if len(buildErr.Cancelled) != 2 {
+		t.Fatalf("the other builds should be cancelled: %#v", buildErr.Cancelled)
+	}
+}
+
+func TestBuilderBuild_skipExcluded(t *testing.T) {
+	td := testModule(t, map[string]string{
+		"main.go": "//go:build linux\n\npackage main\n\nfunc main() {}\n",
+	})
+
+	linux := Platform{OS: "linux", Arch: "amd64"}
+	windows := Platform{OS: "windows", Arch: "amd64"}
+	b := &Builder{
+		Packages:  []string{"_" + filepath.ToSlash(td)},
+		Platforms: []Platform{linux, windows},
+		Opts: CompileOpts{
+			OutputTpl: filepath.Join(td, "dist", "{{.OS}}_{{.Arch}}"),
+			GoCmd:     "go",
+		},
+		SkipExcluded: true,
+	}
+
+	results, err := b.Build(context.Background())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("bad: %#v", results)
+	}
+
+	for _, result := range results {
+		switch result.Platform {
+		case linux:
+			if result.Skipped || result.Output == "" {
+				t.Fatalf("bad: %#v", result)
+			}
+		case windows:
+			if !result.Skipped || result.SkipReason == "" || result.Output != "" {
+				t.Fatalf("bad: %#v", result)
+			}
+		}
+	}
+}
+
+func TestBuilderCheck_outputs(t *testing.T) {
+	td := testModule(t, nil)
+	linux := Platform{OS: "linux", Arch: "amd64"}
+	darwin := Platform{OS: "darwin", Arch: "amd64"}
+	cases := []struct {
+		Tpl        string
+		Collisions int
+		Errors     int
+	}{
+		{filepath.Join(td, "{{.OS}}_{{.Arch}}"), 0, 0},
+		{filepath.Join(td, "{{.Arch}}"), 1, 1},
+		{filepath.Join(td, "{{.Nope}}"), 0, 1},
+		{filepath.Join(td, "{{.OS"), 0, 1},
+	}
+
+	for _, tc := range cases {
+		b := &Builder{
+			Packages:  []string{"_" + filepath.ToSlash(td)},
+			Platforms: []Platform{linux, darwin},
+			Opts: CompileOpts{
+				OutputTpl: tc.Tpl,
+				GoCmd:     "go",
+			},
+		}
+
+		err := b.Check(context.Background())
+		if tc.Errors == 0 {
+			if err != nil {
+				t.Fatalf("%s: err: %s", tc.Tpl, err)
+			}
+			continue
+		}
+
+		checkErr, ok := err.(*CheckError)
+		if !ok {
+			t.Fatalf("%s: err: %s", tc.Tpl, err)
+		}
+		if len(checkErr.Errors) != tc.Errors {
+			t.Fatalf("%s: bad: %#v", tc.Tpl, checkErr.Errors)
+		}
+
+		collisions := 0
+		for _, err := range checkErr.Errors {
+			if err, ok := err.(*OutputCollisionError); ok {
+				collisions++
+				if len(err.Jobs) != 2 {
+					t.Fatalf("%s: bad: %#v", tc.Tpl, err)
+				}
+			}
+		}
+		if collisions != tc.Collisions {
+			t.Fatalf("%s: bad: %#v", tc.Tpl, checkErr.Errors)
+		}
+	}
+}
+
+func TestBuilderCheck_skipExcluded(t *testing.T) {
+	td := testModule(t, map[string]string{
+		"main.go": "//go:build linux\n\npackage main\n\nfunc main() {}\n",
+	})
+
+	// Both platforms would write to the same path, but the package can't
+	// be built for windows
+	b := &Builder{
+		Packages: []string{"_" + filepath.ToSlash(td)},
+		Platforms: []Platform{
+			{OS: "linux", Arch: "amd64"},
+			{OS: "windows", Arch: "amd64"},
+		},
+		Opts: CompileOpts{
+			OutputTpl: filepath.Join(td, "dist", "{{.Dir}}"),
+			NoExt:     true,
+			GoCmd:     "go",
+		},
+	}
+	if _, ok := b.Check(context.Background()).(*CheckError); !ok {
+		t.Fatal("should collide")
+	}
+
+	b.SkipExcluded = true
+	if err := b.Check(context.Background()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBuilderJobOpts_copiesSlices(t *testing.T) {
+	b := &Builder{
+		Override: func(opts *CompileOpts) error {
+			opts.LinkVars = append(opts.LinkVars, "main.os="+opts.Platform.OS)
+			opts.Env = append(opts.Env, "GOOS_NAME="+opts.Platform.OS)
+			return nil
+		},
+	}
+
+	// Spare capacity, so appending doesn't have to copy
+	base := CompileOpts{
+		PackageInfo: &PackageInfo{},
+		LinkVars:    append(make([]string, 0, 4), "main.a=1"),
+		Env:         append(make([]string, 0, 4), "A=1"),
+	}
+	linux, err := b.jobOpts(base, "a", Platform{OS: "linux", Arch: "amd64"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := b.jobOpts(base, "a", Platform{OS: "windows", Arch: "amd64"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if linux.LinkVars[1] != "main.os=linux" || linux.Env[1] != "GOOS_NAME=linux" {
+		t.Fatalf("bad: %#v", linux)
+	}
+}
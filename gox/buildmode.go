@@ -0,0 +1,152 @@
+package gox
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// buildModes are the valid values of -buildmode.
+var buildModes = map[string]struct{}{
+	"archive":   {},
+	"c-archive": {},
+	"c-shared":  {},
+	"default":   {},
+	"exe":       {},
+	"pie":       {},
+	"plugin":    {},
+	"shared":    {},
+}
+
+// buildModePlatforms are the platforms that support each build mode that
+// isn't supported everywhere. This mirrors the table in the go command.
+var buildModePlatforms = map[string]map[string]struct{}{
+	"c-archive": platformSet(
+		"aix/ppc64",
+		"darwin/amd64", "darwin/arm64",
+		"ios/amd64", "ios/arm64",
+		"linux/386", "linux/amd64", "linux/arm", "linux/arm64", "linux/loong64",
+		"linux/ppc64le", "linux/riscv64", "linux/s390x",
+		"freebsd/amd64",
+		"windows/386", "windows/amd64", "windows/arm", "windows/arm64",
+	),
+	"c-shared": platformSet(
+		"linux/386", "linux/amd64", "linux/arm", "linux/arm64", "linux/loong64",
+		"linux/ppc64le", "linux/riscv64", "linux/s390x",
+		"android/386", "android/amd64", "android/arm", "android/arm64",
+		"freebsd/amd64",
+		"darwin/amd64", "darwin/arm64",
+		"windows/386", "windows/amd64", "windows/arm64",
+	),
+	"pie": platformSet(
+		"linux/386", "linux/amd64", "linux/arm", "linux/arm64", "linux/loong64",
+		"linux/ppc64le", "linux/riscv64", "linux/s390x",
+		"android/386", "android/amd64", "android/arm", "android/arm64",
+		"freebsd/amd64",
+		"darwin/amd64", "darwin/arm64",
+		"ios/amd64", "ios/arm64",
+		"aix/ppc64",
+		"windows/386", "windows/amd64", "windows/arm", "windows/arm64",
+	),
+	"plugin": platformSet(
+		"linux/386", "linux/amd64", "linux/arm", "linux/arm64", "linux/loong64",
+		"linux/ppc64le", "linux/s390x",
+		"android/386", "android/amd64",
+		"darwin/amd64", "darwin/arm64",
+		"freebsd/amd64",
+	),
+	"shared": platformSet(
+		"linux/386", "linux/amd64", "linux/arm", "linux/arm64",
+		"linux/ppc64le", "linux/s390x",
+	),
+}
+
+// cgoBuildModes are the build modes that link with the C toolchain, and
+// so need cgo.
+var cgoBuildModes = map[string]struct{}{
+	"c-archive": {},
+	"c-shared":  {},
+	"plugin":    {},
+	"shared":    {},
+}
+
+func platformSet(platforms ...string) map[string]struct{} {
+	result := make(map[string]struct{}, len(platforms))
+	for _, p := range platforms {
+		result[p] = struct{}{}
+	}
+
+	return result
+}
+
+// UnsupportedBuildModeError is returned by GoCrossCompile when the build
+// mode isn't supported on the platform.
+type UnsupportedBuildModeError struct {
+	Platform  Platform
+	BuildMode string
+}
+
+func (e *UnsupportedBuildModeError) Error() string {
+	return fmt.Sprintf("-buildmode=%s is not supported on %s",
+		e.BuildMode, e.Platform.String())
+}
+
+// ValidateBuildMode returns an error if mode isn't a valid -buildmode.
+func ValidateBuildMode(mode string) error {
+	if _, ok := buildModes[mode]; !ok && mode != "" {
+		return fmt.Errorf("unknown build mode: %s", mode)
+	}
+
+	return nil
+}
+
+// BuildModeSupported returns true if the platform supports the build mode.
+// An empty mode is the default and is supported everywhere.
+func BuildModeSupported(platform Platform, mode string) bool {
+	if _, ok := buildModes[mode]; !ok && mode != "" {
+		return false
+	}
+
+	platforms, ok := buildModePlatforms[mode]
+	if !ok {
+		return true
+	}
+
+	_, ok = platforms[platform.OS+"/"+platform.Arch]
+	return ok
+}
+
+// checkBuildMode returns an error if the options can't be built with
+// their build mode.
+func checkBuildMode(opts *CompileOpts) error {
+	if err := ValidateBuildMode(opts.BuildMode); err != nil {
+		return err
+	}
+
+	if !BuildModeSupported(opts.Platform, opts.BuildMode) {
+		return &UnsupportedBuildModeError{
+			Platform:  opts.Platform,
+			BuildMode: opts.BuildMode,
+		}
+	}
+
+	if _, ok := cgoBuildModes[opts.BuildMode]; ok && !opts.Cgo {
+		return fmt.Errorf(
+			"-buildmode=%s requires cgo, which is only enabled by default "+
+				"for the host platform. Use -cgo with a C cross-compiler",
+			opts.BuildMode)
+	}
+
+	return nil
+}
+
+// headerPath returns the path to the C header that go build writes next
+// to the output for the c-archive and c-shared build modes, or an empty
+// string for other build modes.
+func headerPath(output string, mode string) string {
+	if mode != "c-archive" && mode != "c-shared" {
+		return ""
+	}
+
+	return strings.TrimSuffix(output, filepath.Ext(output)) + ".h"
+}
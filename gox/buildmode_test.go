@@ -0,0 +1,84 @@
+package gox
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestBuildModeSupported(t *testing.T) {
+	cases := []struct {
+		Platform  Platform
+		BuildMode string
+		Supported bool
+	}{
+		{Platform{OS: "plan9", Arch: "386"}, "", true},
+		{Platform{OS: "plan9", Arch: "386"}, "exe", true},
+		{Platform{OS: "linux", Arch: "amd64"}, "c-shared", true},
+		{Platform{OS: "windows", Arch: "amd64"}, "c-shared", true},
+		{Platform{OS: "openbsd", Arch: "amd64"}, "c-shared", false},
+		{Platform{OS: "linux", Arch: "amd64"}, "plugin", true},
+		{Platform{OS: "windows", Arch: "amd64"}, "plugin", false},
+		{Platform{OS: "linux", Arch: "amd64"}, "dll", false},
+	}
+
+	for _, tc := range cases {
+		if BuildModeSupported(tc.Platform, tc.BuildMode) != tc.Supported {
+			t.Fatalf("%s %s: should be %v", tc.Platform.String(), tc.BuildMode, tc.Supported)
+		}
+	}
+}
+
+func TestCheckBuildMode(t *testing.T) {
+	opts := &CompileOpts{
+		Platform:  Platform{OS: "windows", Arch: "amd64"},
+		BuildMode: "plugin",
+	}
+	if _, ok := checkBuildMode(opts).(*UnsupportedBuildModeError); !ok {
+		t.Fatalf("bad: %#v", checkBuildMode(opts))
+	}
+
+	opts.BuildMode = "c-shared"
+	if err := checkBuildMode(opts); err == nil {
+		t.Fatal("should require cgo")
+	}
+
+	opts.Cgo = true
+	if err := checkBuildMode(opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestGoCrossCompile_cShared(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("only tested on linux")
+	}
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+
+	td := testModuleDir(t, map[string]string{
+		"main.go": "package main\n\nimport \"C\"\n\n//export Foo\nfunc Foo() {}\n\nfunc main() {}\n",
+	})
+
+	opts := &CompileOpts{
+		PackagePath: "_" + filepath.ToSlash(td),
+		Platform:    Platform{OS: runtime.GOOS, Arch: runtime.GOARCH},
+		OutputTpl:   filepath.Join(td, "dist", "libfoo"),
+		BuildMode:   "c-shared",
+		GoCmd:       "go",
+	}
+	output, err := GoCrossCompile(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if output != filepath.Join(td, "dist", "libfoo.so") {
+		t.Fatalf("bad: %s", output)
+	}
+	if _, err := os.Stat(filepath.Join(td, "dist", "libfoo.h")); err != nil {
+		t.Fatalf("header should be next to the library: %s", err)
+	}
+}
@@ -0,0 +1,337 @@
+package gox
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// BuildCache is an index of the outputs that have been built and the key
+// of the build that produced them, which is used to skip building
+// packages that haven't changed. The outputs themselves stay where they
+// were built; only their paths, keys and digests are recorded.
+type BuildCache struct {
+	path string
+
+	lock    sync.Mutex
+	entries map[string]*cacheEntry
+	dirty   bool
+}
+
+type cacheEntry struct {
+	// Key identifies everything that went into the build. See cacheKey.
+	Key string `json:"key"`
+
+	// Files are the SHA-256 digests of the output and any files written
+	// next to it, so that outputs that were changed or removed since are
+	// built again.
+	Files map[string]string `json:"files"`
+}
+
+// DefaultBuildCachePath returns the path to the index in the user's cache
+// directory.
+func DefaultBuildCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "gox", "builds.json"), nil
+}
+
+// OpenBuildCache reads the cache index at the given path. The index
+// doesn't have to exist yet; it is written by Save.
+func OpenBuildCache(path string) (*BuildCache, error) {
+	c := &BuildCache{
+		path:    path,
+		entries: make(map[string]*cacheEntry),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return c, nil
+}
+
+// Fresh returns true if output was built with the given key and none of
+// the files built with it have changed since.
+func (c *BuildCache) Fresh(output string, key string) bool {
+	c.lock.Lock()
+	entry, ok := c.entries[output]
+	c.lock.Unlock()
+	if !ok || entry.Key != key {
+		return false
+	}
+
+	for path, digest := range entry.Files {
+		actual, err := fileDigest(path)
+		if err != nil || actual != digest {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Put records that output, along with the other files, was built with
+// the given key.
+func (c *BuildCache) Put(output string, key string, files []string) error {
+	entry := &cacheEntry{
+		Key:   key,
+		Files: make(map[string]string, len(files)),
+	}
+	for _, path := range files {
+		digest, err := fileDigest(path)
+		if err != nil {
+			return err
+		}
+
+		entry.Files[path] = digest
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries[output] = entry
+	c.dirty = true
+	return nil
+}
+
+// Save writes the index if anything was added to it, replacing the file
+// atomically so that a concurrent gox never reads a partial index.
+func (c *BuildCache) Save() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	// Entries for outputs that no longer exist are dropped, so that the
+	// index doesn't grow forever.
+	for output := range c.entries {
+		if _, err := os.Stat(output); err != nil {
+			delete(c.entries, output)
+		}
+	}
+
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(c.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".builds-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), c.path); err != nil {
+		return err
+	}
+
+	c.dirty = false
+	return nil
+}
+
+// cacheEnvPrefixes are the prefixes of the environment variables that
+// can change the output of go build, and so are part of the cache key.
+var cacheEnvPrefixes = []string{"GO", "CGO_", "CC=", "CXX=", "AR=", "PKG_CONFIG"}
+
+// cacheKey returns the key for running go build with the given args and
+// env, which covers the args, the relevant env, the version of Go and
+// the contents of every package that is built, as found by go list.
+func cacheKey(ctx context.Context, GoCmd string, env []string, dir string, args []string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "dir %s\n", dir)
+	for _, arg := range args {
+		// These don't change the output, and -rebuild adds -a, which
+		// should still leave the output cached for the next run.
+		if arg == "-a" || arg == "-v" {
+			continue
+		}
+
+		fmt.Fprintf(h, "arg %q\n", arg)
+	}
+
+	var keyEnv []string
+	for _, kv := range env {
+		for _, prefix := range cacheEnvPrefixes {
+			if strings.HasPrefix(kv, prefix) {
+				keyEnv = append(keyEnv, kv)
+				break
+			}
+		}
+	}
+	sort.Strings(keyEnv)
+	for _, kv := range keyEnv {
+		fmt.Fprintf(h, "env %q\n", kv)
+	}
+
+	version, err := execGo(ctx, GoCmd, env, dir, "version")
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "version %s\n", version)
+
+	// go list needs the flags that change which packages and files are
+	// part of the build.
+	listArgs := []string{"list", "-deps", "-json"}
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "-tags", "-mod":
+			listArgs = append(listArgs, args[i], args[i+1])
+			i++
+		case "-race":
+			listArgs = append(listArgs, args[i])
+		}
+	}
+	pkg := args[len(args)-1]
+	if pkg == "" {
+		pkg = "."
+	}
+	listArgs = append(listArgs, pkg)
+
+	output, err := execGo(ctx, GoCmd, env, dir, listArgs...)
+	if err != nil {
+		return "", err
+	}
+
+	dec := json.NewDecoder(strings.NewReader(output))
+	for {
+		var p listPackage
+		if err := dec.Decode(&p); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+
+		if err := p.hash(h); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// listPackage is the part of the output of go list -json that gox uses,
+// to find main packages and to decide what a package compiles to.
+type listPackage struct {
+	Name       string
+	ImportPath string
+	Dir        string
+	Standard   bool
+	Module     *struct {
+		Path    string
+		Version string
+		GoMod   string
+		Replace *struct{ Path string }
+	}
+
+	GoFiles, CgoFiles, CFiles, CXXFiles, MFiles, HFiles, FFiles []string
+	SFiles, SwigFiles, SwigCXXFiles, SysoFiles, EmbedFiles      []string
+	IgnoredGoFiles                                              []string
+
+	// Error is set with go list -e if the package couldn't be loaded, and
+	// DepsErrors if any of its imports couldn't be.
+	Error      *listError
+	DepsErrors []*listError
+}
+
+type listError struct {
+	Err string
+}
+
+// err returns the error loading the package or its imports, or an empty
+// string if there was none.
+func (p *listPackage) err() string {
+	if p.Error != nil {
+		return p.Error.Err
+	}
+	if len(p.DepsErrors) > 0 {
+		return p.DepsErrors[0].Err
+	}
+
+	return ""
+}
+
+// hash writes the package to h. Standard packages are covered by the
+// version of Go, and packages from a module version by that version, so
+// only the files of the other packages are read.
+func (p *listPackage) hash(h io.Writer) error {
+	fmt.Fprintf(h, "package %s\n", p.ImportPath)
+	if p.Standard {
+		return nil
+	}
+	if p.Module != nil && p.Module.Version != "" && p.Module.Replace == nil {
+		fmt.Fprintf(h, "module %s@%s\n", p.Module.Path, p.Module.Version)
+		return nil
+	}
+
+	if p.Module != nil && p.Module.GoMod != "" {
+		digest, err := fileDigest(p.Module.GoMod)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(h, "go.mod %s\n", digest)
+	}
+
+	for _, list := range [][]string{
+		p.GoFiles, p.CgoFiles, p.CFiles, p.CXXFiles, p.MFiles, p.HFiles, p.FFiles,
+		p.SFiles, p.SwigFiles, p.SwigCXXFiles, p.SysoFiles, p.EmbedFiles,
+	} {
+		for _, name := range list {
+			digest, err := fileDigest(filepath.Join(p.Dir, name))
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(h, "file %s %s\n", name, digest)
+		}
+	}
+
+	return nil
+}
+
+// fileDigest returns the hex SHA-256 digest of the file.
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,103 @@
+package gox
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildCache(t *testing.T) {
+	td, err := ioutil.TempDir("", "gox")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	output := filepath.Join(td, "foo")
+	if err := ioutil.WriteFile(output, []byte("foo"), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	path := filepath.Join(td, "cache", "builds.json")
+	c, err := OpenBuildCache(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if c.Fresh(output, "key") {
+		t.Fatal("should not be fresh")
+	}
+	if err := c.Put(output, "key", []string{output}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := c.Save(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	c, err = OpenBuildCache(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !c.Fresh(output, "key") {
+		t.Fatal("should be fresh")
+	}
+	if c.Fresh(output, "other") {
+		t.Fatal("should not be fresh with another key")
+	}
+
+	if err := ioutil.WriteFile(output, []byte("bar"), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if c.Fresh(output, "key") {
+		t.Fatal("should not be fresh after the output changed")
+	}
+}
+
+func TestGoCrossCompileCached(t *testing.T) {
+	td := testModule(t, map[string]string{
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+
+	cache, err := OpenBuildCache(filepath.Join(td, "builds.json"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	build := func(opts CompileOpts) bool {
+		opts.PackagePath = "_" + filepath.ToSlash(td)
+		opts.Platform = Platform{OS: "linux", Arch: "amd64"}
+		opts.OutputTpl = filepath.Join(td, "dist", "foo")
+		opts.GoCmd = "go"
+		_, cached, err := GoCrossCompileCached(context.Background(), &opts, cache)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		return cached
+	}
+
+	if build(CompileOpts{}) {
+		t.Fatal("first build should not be cached")
+	}
+	if !build(CompileOpts{}) {
+		t.Fatal("second build should be cached")
+	}
+	if build(CompileOpts{Rebuild: true}) {
+		t.Fatal("-rebuild should not be cached")
+	}
+	if !build(CompileOpts{}) {
+		t.Fatal("build after -rebuild should be cached")
+	}
+	if build(CompileOpts{Ldflags: "-s"}) {
+		t.Fatal("build with other flags should not be cached")
+	}
+
+	source := "package main\n\nfunc main() { println() }\n"
+	if err := ioutil.WriteFile(filepath.Join(td, "main.go"), []byte(source), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if build(CompileOpts{Ldflags: "-s"}) {
+		t.Fatal("build after the source changed should not be cached")
+	}
+}
@@ -0,0 +1,180 @@
+package gox
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// CgoOpts are the settings for the C toolchain used by cgo builds. Any
+// that are empty are left to the environment.
+type CgoOpts struct {
+	// CC and CXX are the C and C++ compilers. These may include arguments,
+	// such as "zig cc -target aarch64-linux-gnu".
+	CC  string
+	CXX string
+
+	CFlags        string
+	CXXFlags      string
+	LDFlags       string
+	PkgConfigPath string
+
+	// Sysroot, if set, is passed to the compilers and linker with
+	// --sysroot, and is used as the root for pkg-config.
+	Sysroot string
+}
+
+// env returns the environment variables for the settings.
+func (c *CgoOpts) env() []string {
+	cflags, cxxflags, ldflags := c.CFlags, c.CXXFlags, c.LDFlags
+	if c.Sysroot != "" {
+		sysroot := "--sysroot=" + c.Sysroot
+		cflags = strings.TrimSpace(cflags + " " + sysroot)
+		cxxflags = strings.TrimSpace(cxxflags + " " + sysroot)
+		ldflags = strings.TrimSpace(ldflags + " " + sysroot)
+	}
+
+	var result []string
+	for _, v := range []struct {
+		key   string
+		value string
+	}{
+		{"CC", c.CC},
+		{"CXX", c.CXX},
+		{"CGO_CFLAGS", cflags},
+		{"CGO_CXXFLAGS", cxxflags},
+		{"CGO_LDFLAGS", ldflags},
+		{"PKG_CONFIG_PATH", c.PkgConfigPath},
+	} {
+		if v.value != "" {
+			result = append(result, v.key+"="+v.value)
+		}
+	}
+	if c.Sysroot != "" {
+		result = append(result, "PKG_CONFIG_SYSROOT_DIR="+c.Sysroot)
+	}
+
+	return result
+}
+
+// crossCompilers are the prefixes of the GCC cross-compilers for each
+// platform, as packaged by most Linux distributions, and the osxcross
+// wrappers for macOS. These are searched for in order.
+var crossCompilers = map[string][]string{
+	"linux/386":      {"i686-linux-gnu"},
+	"linux/amd64":    {"x86_64-linux-gnu"},
+	"linux/arm":      {"arm-linux-gnueabihf", "arm-linux-gnueabi"},
+	"linux/arm64":    {"aarch64-linux-gnu"},
+	"linux/mips":     {"mips-linux-gnu"},
+	"linux/mipsle":   {"mipsel-linux-gnu"},
+	"linux/mips64":   {"mips64-linux-gnuabi64"},
+	"linux/mips64le": {"mips64el-linux-gnuabi64"},
+	"linux/ppc64le":  {"powerpc64le-linux-gnu"},
+	"linux/riscv64":  {"riscv64-linux-gnu"},
+	"linux/s390x":    {"s390x-linux-gnu"},
+	"windows/386":    {"i686-w64-mingw32"},
+	"windows/amd64":  {"x86_64-w64-mingw32"},
+	"darwin/amd64":   {"o64"},
+	"darwin/arm64":   {"oa64"},
+}
+
+// zigTargets are the targets to give `zig cc` for each platform. Zig is
+// used when there is no other cross-compiler, since it can target all
+// of these from a single install.
+var zigTargets = map[string]string{
+	"linux/386":     "x86-linux-gnu",
+	"linux/amd64":   "x86_64-linux-gnu",
+	"linux/arm":     "arm-linux-gnueabihf",
+	"linux/arm64":   "aarch64-linux-gnu",
+	"linux/ppc64le": "powerpc64le-linux-gnu",
+	"linux/riscv64": "riscv64-linux-gnu",
+	"linux/s390x":   "s390x-linux-gnu",
+	"windows/386":   "x86-windows-gnu",
+	"windows/amd64": "x86_64-windows-gnu",
+	"windows/arm64": "aarch64-windows-gnu",
+	"darwin/amd64":  "x86_64-macos",
+	"darwin/arm64":  "aarch64-macos",
+}
+
+// lookPath is exec.LookPath, replaced in tests.
+var lookPath = exec.LookPath
+
+// NoCompilerError is returned when cgo is enabled for a platform but no
+// C compiler for it could be found.
+type NoCompilerError struct {
+	Platform Platform
+}
+
+func (e *NoCompilerError) Error() string {
+	key := strings.ToUpper(fmt.Sprintf("GOX_%s_%s_CC", e.Platform.OS, e.Platform.Arch))
+	msg := fmt.Sprintf("cgo is enabled for %s but no C cross-compiler was found. "+
+		"Set %s", e.Platform.String(), key)
+	if prefixes := crossCompilers[e.Platform.OS+"/"+e.Platform.Arch]; len(prefixes) > 0 {
+		msg += fmt.Sprintf(", or install %s-gcc or zig", prefixes[0])
+	}
+
+	return msg
+}
+
+// DetectCC finds a C cross-compiler for the platform on the PATH. The
+// GCC cross-compilers and osxcross are preferred, followed by zig. False
+// is returned if none was found.
+func DetectCC(platform Platform) (CgoOpts, bool) {
+	key := platform.OS + "/" + platform.Arch
+	for _, prefix := range crossCompilers[key] {
+		cc, cxx := prefix+"-gcc", prefix+"-g++"
+		if platform.OS == "darwin" {
+			cc, cxx = prefix+"-clang", prefix+"-clang++"
+		}
+
+		if _, err := lookPath(cc); err != nil {
+			continue
+		}
+
+		result := CgoOpts{CC: cc}
+		if _, err := lookPath(cxx); err == nil {
+			result.CXX = cxx
+		}
+
+		return result, true
+	}
+
+	if target, ok := zigTargets[key]; ok {
+		if _, err := lookPath("zig"); err == nil {
+			return CgoOpts{
+				CC:  "zig cc -target " + target,
+				CXX: "zig c++ -target " + target,
+			}, true
+		}
+	}
+
+	return CgoOpts{}, false
+}
+
+// ResolveCgo makes sure there is a C compiler for the options if cgo is
+// enabled, detecting a cross-compiler with DetectCC if one wasn't given.
+// The host compiler is used for the host platform, as is CC from the
+// environment if it is set. A *NoCompilerError is returned if there is
+// no compiler for the platform.
+func ResolveCgo(opts *CompileOpts) error {
+	if !opts.Cgo || opts.CgoOpts.CC != "" || os.Getenv("CC") != "" {
+		return nil
+	}
+	if opts.Platform.OS == runtime.GOOS && opts.Platform.Arch == runtime.GOARCH {
+		return nil
+	}
+
+	detected, ok := DetectCC(opts.Platform)
+	if !ok {
+		return &NoCompilerError{Platform: opts.Platform}
+	}
+
+	opts.CgoOpts.CC = detected.CC
+	if opts.CgoOpts.CXX == "" {
+		opts.CgoOpts.CXX = detected.CXX
+	}
+
+	return nil
+}
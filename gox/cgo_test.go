@@ -0,0 +1,169 @@
+package gox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func testLookPath(t *testing.T, found ...string) {
+	old := lookPath
+	t.Cleanup(func() { lookPath = old })
+
+	lookPath = func(name string) (string, error) {
+		for _, f := range found {
+			if f == name {
+				return "/usr/bin/" + name, nil
+			}
+		}
+
+		return "", errors.New("not found")
+	}
+}
+
+func TestCgoOptsEnv(t *testing.T) {
+	c := &CgoOpts{
+		CC:      "aarch64-linux-gnu-gcc",
+		CFlags:  "-O2",
+		Sysroot: "/sysroot",
+	}
+
+	expected := []string{
+		"CC=aarch64-linux-gnu-gcc",
+		"CGO_CFLAGS=-O2 --sysroot=/sysroot",
+		"CGO_CXXFLAGS=--sysroot=/sysroot",
+		"CGO_LDFLAGS=--sysroot=/sysroot",
+		"PKG_CONFIG_SYSROOT_DIR=/sysroot",
+	}
+	if env := c.env(); !reflect.DeepEqual(env, expected) {
+		t.Fatalf("bad: %#v", env)
+	}
+}
+
+func TestDetectCC(t *testing.T) {
+	cases := []struct {
+		Found    []string
+		Platform Platform
+		Result   CgoOpts
+		OK       bool
+	}{
+		{
+			[]string{"aarch64-linux-gnu-gcc", "aarch64-linux-gnu-g++", "zig"},
+			Platform{OS: "linux", Arch: "arm64"},
+			CgoOpts{CC: "aarch64-linux-gnu-gcc", CXX: "aarch64-linux-gnu-g++"},
+			true,
+		},
+		{
+			[]string{"arm-linux-gnueabi-gcc"},
+			Platform{OS: "linux", Arch: "arm", Variant: "5"},
+			CgoOpts{CC: "arm-linux-gnueabi-gcc"},
+			true,
+		},
+		{
+			[]string{"oa64-clang", "oa64-clang++"},
+			Platform{OS: "darwin", Arch: "arm64"},
+			CgoOpts{CC: "oa64-clang", CXX: "oa64-clang++"},
+			true,
+		},
+		{
+			[]string{"zig"},
+			Platform{OS: "windows", Arch: "amd64"},
+			CgoOpts{CC: "zig cc -target x86_64-windows-gnu", CXX: "zig c++ -target x86_64-windows-gnu"},
+			true,
+		},
+		{
+			[]string{"zig"},
+			Platform{OS: "plan9", Arch: "amd64"},
+			CgoOpts{},
+			false,
+		},
+	}
+
+	for _, tc := range cases {
+		testLookPath(t, tc.Found...)
+		result, ok := DetectCC(tc.Platform)
+		if ok != tc.OK || result != tc.Result {
+			t.Fatalf("%s: bad: %#v %v", tc.Platform.String(), result, ok)
+		}
+	}
+}
+
+func TestResolveCgo(t *testing.T) {
+	if os.Getenv("CC") != "" {
+		t.Skip("CC is set in the environment")
+	}
+
+	testLookPath(t)
+	opts := &CompileOpts{
+		Platform: Platform{OS: "plan9", Arch: "amd64"},
+		Cgo:      true,
+	}
+	if _, ok := ResolveCgo(opts).(*NoCompilerError); !ok {
+		t.Fatalf("bad: %#v", ResolveCgo(opts))
+	}
+
+	// The host and explicit compilers don't need detecting
+	opts.CgoOpts.CC = "cc"
+	if err := ResolveCgo(opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	opts = &CompileOpts{
+		Platform: Platform{OS: runtime.GOOS, Arch: runtime.GOARCH},
+		Cgo:      true,
+	}
+	if err := ResolveCgo(opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	testLookPath(t, "x86_64-w64-mingw32-gcc")
+	opts = &CompileOpts{
+		Platform: Platform{OS: "windows", Arch: "amd64"},
+		Cgo:      true,
+	}
+	if err := ResolveCgo(opts); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if opts.CgoOpts.CC != "x86_64-w64-mingw32-gcc" {
+		t.Fatalf("bad: %#v", opts.CgoOpts)
+	}
+}
+
+func TestBuilderCheck(t *testing.T) {
+	if os.Getenv("CC") != "" {
+		t.Skip("CC is set in the environment")
+	}
+
+	testLookPath(t)
+	b := &Builder{
+		Packages: []string{"a", "b"},
+		Platforms: []Platform{
+			{OS: "plan9", Arch: "amd64"},
+			{OS: "plan9", Arch: "386"},
+		},
+		Opts: CompileOpts{
+			Cgo:       true,
+			OutputTpl: "{{.Dir}}_{{.OS}}_{{.Arch}}",
+		},
+		Override: func(opts *CompileOpts) error {
+			if opts.Platform.Arch == "386" {
+				opts.Cgo = false
+			}
+
+			return nil
+		},
+	}
+
+	err, ok := b.Check(context.Background()).(*CheckError)
+	if !ok {
+		t.Fatalf("bad: %#v", b.Check(context.Background()))
+	}
+	if len(err.Errors) != 1 {
+		t.Fatalf("errors should be reported once per platform: %s", err)
+	}
+	if _, ok := err.Errors[0].(*NoCompilerError); !ok {
+		t.Fatalf("bad: %#v", err.Errors[0])
+	}
+}
@@ -0,0 +1,162 @@
+package gox
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// ChecksumJSONFile is the name of the JSON manifest written alongside
+// the coreutils-style manifests when requested.
+const ChecksumJSONFile = "checksums.json"
+
+// checksumAlgorithm is a digest algorithm supported by -checksum, along
+// with the name of the manifest file that its digests are written to.
+// The manifest names match those conventionally used with the coreutils
+// sha256sum, sha512sum and b2sum commands.
+type checksumAlgorithm struct {
+	Manifest string
+	New      func() hash.Hash
+}
+
+var checksumAlgorithms = map[string]checksumAlgorithm{
+	"sha256":  {"SHA256SUMS", sha256.New},
+	"sha512":  {"SHA512SUMS", sha512.New},
+	"blake2b": {"B2SUMS", newBlake2b},
+}
+
+func newBlake2b() hash.Hash {
+	// New512 only errors when given a key that is too long.
+	h, err := blake2b.New512(nil)
+	if err != nil {
+		panic(err)
+	}
+
+	return h
+}
+
+// Checksum is the set of digests for a single artifact.
+type Checksum struct {
+	Path    string            `json:"path"`
+	Digests map[string]string `json:"digests"`
+}
+
+// ValidateChecksumAlgorithms returns an error if any of the given
+// algorithms aren't supported.
+func ValidateChecksumAlgorithms(algorithms []string) error {
+	for _, a := range algorithms {
+		if _, ok := checksumAlgorithms[a]; !ok {
+			return fmt.Errorf("unknown checksum algorithm: %s", a)
+		}
+	}
+
+	return nil
+}
+
+// ChecksumFile computes the digests of the file at path for each of the
+// given algorithms. The file is only read once, no matter how many
+// algorithms are requested.
+func ChecksumFile(path string, algorithms []string) (*Checksum, error) {
+	hashes := make([]hash.Hash, len(algorithms))
+	writers := make([]io.Writer, len(algorithms))
+	for i, a := range algorithms {
+		algorithm, ok := checksumAlgorithms[a]
+		if !ok {
+			return nil, fmt.Errorf("unknown checksum algorithm: %s", a)
+		}
+
+		hashes[i] = algorithm.New()
+		writers[i] = hashes[i]
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(io.MultiWriter(writers...), f); err != nil {
+		return nil, err
+	}
+
+	result := &Checksum{
+		Path:    path,
+		Digests: make(map[string]string, len(algorithms)),
+	}
+	for i, a := range algorithms {
+		result.Digests[a] = hex.EncodeToString(hashes[i].Sum(nil))
+	}
+
+	return result, nil
+}
+
+// WriteChecksums writes a manifest for each of the given algorithms into
+// dir, in the format used by the coreutils sha256sum command. The paths
+// in the manifests are relative to dir so that the manifests can be
+// checked with `sha256sum -c` from within it. If writeJSON is true, a
+// JSON manifest with every digest is written as well.
+func WriteChecksums(dir string, algorithms []string, sums []*Checksum, writeJSON bool) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	// Make every path relative to the manifest and sort them so that the
+	// manifests are the same no matter what order the builds finished in.
+	relSums := make([]*Checksum, len(sums))
+	for i, sum := range sums {
+		rel, err := filepath.Rel(dir, sum.Path)
+		if err != nil {
+			return err
+		}
+
+		relSums[i] = &Checksum{
+			Path:    filepath.ToSlash(rel),
+			Digests: sum.Digests,
+		}
+	}
+	sort.Slice(relSums, func(i, j int) bool {
+		return relSums[i].Path < relSums[j].Path
+	})
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for _, a := range algorithms {
+		var manifest strings.Builder
+		for _, sum := range relSums {
+			fmt.Fprintf(&manifest, "%s  %s\n", sum.Digests[a], sum.Path)
+		}
+
+		path := filepath.Join(dir, checksumAlgorithms[a].Manifest)
+		if err := ioutil.WriteFile(path, []byte(manifest.String()), 0644); err != nil {
+			return err
+		}
+	}
+
+	if writeJSON {
+		data, err := json.MarshalIndent(relSums, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(dir, ChecksumJSONFile)
+		if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package gox
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChecksumFile(t *testing.T) {
+	td, err := ioutil.TempDir("", "gox")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	path := filepath.Join(td, "foo")
+	if err := ioutil.WriteFile(path, []byte("hello\n"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	sum, err := ChecksumFile(path, []string{"sha256", "sha512", "blake2b"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]string{
+		"sha256":  "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03",
+		"sha512":  "e7c22b994c59d9cf2b48e549b1e24666636045930d3da7c1acb299d1c3b7f931f94aae41edda2c2b207a36e10f8bcb8d45223e54878f5b316e7ce3b6bc019629",
+		"blake2b": "f60ce482e5cc1229f39d71313171a8d9f4ca3a87d066bf4b205effb528192a75f14f3271e2c1a90e1de53f275b4d4793eef2f5e31ea90d2ce29d2e481c36435f",
+	}
+	for a, digest := range expected {
+		if sum.Digests[a] != digest {
+			t.Fatalf("bad %s: %s", a, sum.Digests[a])
+		}
+	}
+
+	if _, err := ChecksumFile(path, []string{"md5"}); err == nil {
+		t.Fatal("should err")
+	}
+}
+
+func TestWriteChecksums(t *testing.T) {
+	td, err := ioutil.TempDir("", "gox")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	sums := []*Checksum{
+		{
+			Path:    filepath.Join(td, "foo_windows_amd64.exe"),
+			Digests: map[string]string{"sha256": "bbbb"},
+		},
+		{
+			Path:    filepath.Join(td, "sub", "foo_linux_amd64"),
+			Digests: map[string]string{"sha256": "aaaa"},
+		},
+	}
+	if err := WriteChecksums(td, []string{"sha256"}, sums, true); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(td, "SHA256SUMS"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := "bbbb  foo_windows_amd64.exe\naaaa  sub/foo_linux_amd64\n"
+	if string(data) != expected {
+		t.Fatalf("bad: %q", data)
+	}
+
+	data, err = ioutil.ReadFile(filepath.Join(td, ChecksumJSONFile))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var result []*Checksum
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(result) != 2 || result[1].Path != "sub/foo_linux_amd64" {
+		t.Fatalf("bad: %s", data)
+	}
+}
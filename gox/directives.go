@@ -0,0 +1,205 @@
+package gox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// directivePrefix starts the comments that gox reads from the source of
+// main packages, such as:
+//
+//	//gox:platforms linux/* darwin/arm64 !linux/386
+//	//gox:output dist/{{.OS}}_{{.Arch}}/mytool
+//	//gox:ldflags -s -w
+const directivePrefix = "//gox:"
+
+// MainPackage is a main package found by GoMainPackages.
+type MainPackage struct {
+	// ImportPath is the path to give to GoCrossCompile, as returned by
+	// GoMainDirs.
+	ImportPath string
+	Dir        string
+
+	// Directives are read from the //gox: comments in the source.
+	Directives Directives
+}
+
+// Directives are the settings a main package declares for itself with
+// //gox: comments in its source. Empty fields weren't declared.
+type Directives struct {
+	// Platforms are the patterns from //gox:platforms, each an os/arch or
+	// os/arch/variant where the os and arch may be "*". Patterns starting
+	// with "!" exclude platforms. See FilterPlatforms.
+	Platforms []string
+
+	// Output and Ldflags replace the -output and -ldflags for the package.
+	Output  string
+	Ldflags string
+}
+
+// HasIncludes returns true if any of the platform patterns select
+// platforms, rather than only excluding them.
+func (d *Directives) HasIncludes() bool {
+	for _, p := range d.Platforms {
+		if !strings.HasPrefix(p, "!") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UnknownPlatforms returns the patterns in Platforms whose os/arch don't
+// match any of the supported platforms, such as those with a typo. The
+// variant isn't checked, since the supported platforms don't have one.
+func (d *Directives) UnknownPlatforms(supported []Platform) []string {
+	var result []string
+	for _, pattern := range d.Platforms {
+		p := strings.TrimPrefix(pattern, "!")
+		if parts := strings.Split(p, "/"); len(parts) == 3 {
+			p = parts[0] + "/" + parts[1]
+		}
+
+		known := false
+		for _, platform := range supported {
+			if matchPlatform(p, platform) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			result = append(result, pattern)
+		}
+	}
+
+	return result
+}
+
+// FilterPlatforms returns the platforms that match the patterns in
+// Platforms. A platform is kept if it matches any of the patterns that
+// don't start with "!", or there are none of those, and it doesn't match
+// any of the patterns that do.
+func (d *Directives) FilterPlatforms(platforms []Platform) []Platform {
+	if len(d.Platforms) == 0 {
+		return platforms
+	}
+
+	includes := d.HasIncludes()
+	result := make([]Platform, 0, len(platforms))
+	for _, platform := range platforms {
+		include := !includes
+		exclude := false
+		for _, pattern := range d.Platforms {
+			if strings.HasPrefix(pattern, "!") {
+				exclude = exclude || matchPlatform(pattern[1:], platform)
+			} else {
+				include = include || matchPlatform(pattern, platform)
+			}
+		}
+
+		if include && !exclude {
+			result = append(result, platform)
+		}
+	}
+
+	return result
+}
+
+// matchPlatform returns true if the platform matches the pattern. A
+// pattern without a variant matches every variant of the os/arch.
+func matchPlatform(pattern string, platform Platform) bool {
+	name := platform.OS + "/" + platform.Arch
+	if strings.Count(pattern, "/") == 2 {
+		name = platform.String()
+	}
+
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// validPlatformPattern returns an error if the pattern isn't a valid
+// //gox:platforms pattern.
+func validPlatformPattern(pattern string) error {
+	p := strings.TrimPrefix(pattern, "!")
+	parts := strings.Split(p, "/")
+	if len(parts) != 2 && len(parts) != 3 {
+		return fmt.Errorf("invalid platform %q: should be os/arch or os/arch/variant", pattern)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return fmt.Errorf("invalid platform %q: should be os/arch or os/arch/variant", pattern)
+		}
+	}
+	if _, err := path.Match(p, ""); err != nil {
+		return fmt.Errorf("invalid platform %q: %s", pattern, err)
+	}
+
+	return nil
+}
+
+// ParseDirectives reads the //gox: directives from the given Go source
+// files. A directive must start at the beginning of a line. If a
+// directive appears more than once, the platforms are combined and the
+// last output or ldflags wins.
+func ParseDirectives(files []string) (Directives, error) {
+	var result Directives
+	for _, file := range files {
+		if err := parseDirectivesFile(file, &result); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+func parseDirectivesFile(file string, d *Directives) error {
+	// The whole file is read rather than scanned line by line, since
+	// generated sources can have lines of any length.
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	for i, text := range strings.Split(string(data), "\n") {
+		text = strings.TrimSuffix(text, "\r")
+		if !strings.HasPrefix(text, directivePrefix) {
+			continue
+		}
+
+		pos := fmt.Sprintf("%s:%d", filepath.Base(file), i+1)
+		name := strings.TrimPrefix(text, directivePrefix)
+		value := ""
+		if i := strings.IndexAny(name, " \t"); i >= 0 {
+			name, value = name[:i], strings.TrimSpace(name[i:])
+		}
+		if value == "" {
+			return fmt.Errorf("%s: //gox:%s needs a value", pos, name)
+		}
+
+		switch name {
+		case "platforms":
+			for _, pattern := range strings.Fields(value) {
+				if err := validPlatformPattern(pattern); err != nil {
+					return fmt.Errorf("%s: %s", pos, err)
+				}
+
+				d.Platforms = append(d.Platforms, strings.ToLower(pattern))
+			}
+		case "output":
+			if _, err := ParseOutputTemplate(value); err != nil {
+				return fmt.Errorf("%s: invalid output template: %s", pos, err)
+			}
+
+			d.Output = value
+		case "ldflags":
+			d.Ldflags = value
+		default:
+			return fmt.Errorf("%s: unknown directive //gox:%s", pos, name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package gox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDirectives(t *testing.T) {
+	td, err := ioutil.TempDir("", "gox")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	files := []struct {
+		Name     string
+		Contents string
+	}{
+		{"main.go", `// Command foo does things.
+//
+//gox:platforms linux/* darwin/arm64
+//gox:output dist/foo_{{.OS}}_{{.Arch}}
+package main
+
+	//gox:ldflags ignored, since it isn't at the start of the line
+`},
+		{"windows.go", "//gox:platforms !linux/386 Linux/ARM/7\n//gox:ldflags -s -w\n\npackage main\n"},
+	}
+	var paths []string
+	for _, file := range files {
+		path := filepath.Join(td, file.Name)
+		if err := ioutil.WriteFile(path, []byte(file.Contents), 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		paths = append(paths, path)
+	}
+
+	actual, err := ParseDirectives(paths)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Directives{
+		Platforms: []string{"linux/*", "darwin/arm64", "!linux/386", "linux/arm/7"},
+		Output:    "dist/foo_{{.OS}}_{{.Arch}}",
+		Ldflags:   "-s -w",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestParseDirectives_invalid(t *testing.T) {
+	td, err := ioutil.TempDir("", "gox")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	cases := []struct {
+		Contents string
+		Err      string
+	}{
+		{"//gox:platforms linux\n", "main.go:1: invalid platform"},
+		{"//gox:platforms linux/[\n", "main.go:1: invalid platform"},
+		{"package main\n\n//gox:output {{.OS\n", "main.go:3: invalid output template"},
+		{"//gox:ldflags\n", "main.go:1: //gox:ldflags needs a value"},
+		{"//gox:archs amd64\n", "main.go:1: unknown directive //gox:archs"},
+	}
+
+	path := filepath.Join(td, "main.go")
+	for _, tc := range cases {
+		if err := ioutil.WriteFile(path, []byte(tc.Contents), 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		_, err := ParseDirectives([]string{path})
+		if err == nil || !strings.Contains(err.Error(), tc.Err) {
+			t.Fatalf("%q: bad: %v", tc.Contents, err)
+		}
+	}
+}
+
+func TestParseDirectives_longLine(t *testing.T) {
+	td, err := ioutil.TempDir("", "gox")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	// Longer than the default limit of a bufio.Scanner, as embedded data
+	// in generated sources can be
+	contents := "package main\n\nvar data = \"" + strings.Repeat("x", 100*1024) + "\"\n\n" +
+		"//gox:ldflags -s -w\r\n"
+	path := filepath.Join(td, "main.go")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	actual, err := ParseDirectives([]string{path})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if actual.Ldflags != "-s -w" {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestDirectivesFilterPlatforms(t *testing.T) {
+	platforms := []Platform{
+		{"darwin", "amd64", false, ""},
+		{"darwin", "arm64", false, ""},
+		{"linux", "386", false, ""},
+		{"linux", "amd64", false, ""},
+		{"linux", "arm", false, "6"},
+		{"linux", "arm", false, "7"},
+		{"windows", "amd64", false, ""},
+	}
+
+	cases := []struct {
+		Patterns []string
+		Expected []Platform
+	}{
+		{
+			nil,
+			platforms,
+		},
+		{
+			[]string{"linux/*", "darwin/arm64", "!linux/386"},
+			[]Platform{
+				{"darwin", "arm64", false, ""},
+				{"linux", "amd64", false, ""},
+				{"linux", "arm", false, "6"},
+				{"linux", "arm", false, "7"},
+			},
+		},
+		{
+			[]string{"!linux/*", "!*/amd64"},
+			[]Platform{
+				{"darwin", "arm64", false, ""},
+			},
+		},
+		{
+			[]string{"linux/arm/7", "windows/*"},
+			[]Platform{
+				{"linux", "arm", false, "7"},
+				{"windows", "amd64", false, ""},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		d := &Directives{Platforms: tc.Patterns}
+		actual := d.FilterPlatforms(platforms)
+		if !reflect.DeepEqual(actual, tc.Expected) {
+			t.Fatalf("%#v: bad: %#v", tc.Patterns, actual)
+		}
+	}
+}
+
+func TestDirectivesUnknownPlatforms(t *testing.T) {
+	supported := []Platform{
+		{"linux", "amd64", true, ""},
+		{"linux", "arm", true, ""},
+		{"windows", "amd64", true, ""},
+	}
+
+	d := &Directives{
+		Platforms: []string{"linux/*", "!windows/amd64", "linux/arm/7", "linux/amd46", "!plan9/*", "*/arm64/7"},
+	}
+	expected := []string{"linux/amd46", "!plan9/*", "*/arm64/7"}
+	if actual := d.UnknownPlatforms(supported); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
@@ -0,0 +1,887 @@
+package gox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+type CompileOpts struct {
+	PackagePath string
+	Platform    Platform
+	OutputTpl   string
+	Ldflags     string
+	Gcflags     string
+	Asmflags    string
+	Tags        string
+	ModMode     string
+	Cgo         bool
+	Rebuild     bool
+	GoCmd       string
+	Race        bool
+
+	// Verbose, if true, streams the output of the build to Stdout as it
+	// happens, with each line prefixed by the platform. Stdout defaults
+	// to os.Stdout.
+	Verbose bool
+	Stdout  io.Writer
+
+	// Timeout, if non-zero, is how long the build may run before it is
+	// killed and a TimeoutError is returned.
+	Timeout time.Duration
+
+	// CgoOpts are the C toolchain settings for cgo builds. If cgo is
+	// enabled for a platform other than the host and no compiler is set,
+	// one is detected with ResolveCgo.
+	CgoOpts CgoOpts
+
+	// Trimpath, if true, removes file system paths from the binary with
+	// go build -trimpath.
+	Trimpath bool
+
+	// Env are extra environment variables for go build, in the form
+	// KEY=value. These take precedence over everything else, so setting
+	// CGO_ENABLED=0 here disables cgo even for the host platform.
+	Env []string
+
+	// Reproducible, if true, builds so that the output only depends on
+	// the source and the options: file system paths and the build ID are
+	// removed, version control information isn't stamped, and the
+	// variables in reproducibleUnsetEnv are removed from the environment
+	// while the time zone and locale are fixed. This needs Go 1.18 or
+	// later for -buildvcs.
+	Reproducible bool
+
+	// LinkVars are the string variables to set with the linker, each as
+	// importpath.name=value like the -X flag of go tool link. The values
+	// are output templates, so "main.platform={{.OS}}/{{.Arch}}" works.
+	// These are added to the Ldflags.
+	LinkVars []string
+
+	// BuildMode is the -buildmode to pass to go build, if any. It also
+	// decides the extension of the output. The c-archive and c-shared
+	// modes write a C header next to the output, with the extension
+	// replaced by ".h".
+	BuildMode string
+
+	// NoExt, if true, doesn't add the extension for the platform and
+	// build mode to the output path. The template can still use {{.Ext}}.
+	NoExt bool
+
+	// Version and Date are available to the output template. Date
+	// defaults to the current time.
+	Version string
+	Date    time.Time
+
+	// PackageInfo, if set, are the details of the package for the output
+	// template. Otherwise they are looked up for every build, which runs
+	// go list and git, so callers building a package for many platforms
+	// should look them up once with LookupPackageInfo.
+	PackageInfo *PackageInfo
+}
+
+// TimeoutError is returned by GoCrossCompile when the build took longer
+// than the timeout in CompileOpts.
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s", e.Timeout)
+}
+
+// GoCrossCompile builds the package for the platform in the given
+// options and returns the path to the built binary. The build is killed
+// if the context is cancelled.
+func GoCrossCompile(ctx context.Context, opts *CompileOpts) (string, error) {
+	output, _, err := GoCrossCompileCached(ctx, opts, nil)
+	return output, err
+}
+
+// BuildCommand is the go build that GoCrossCompile runs for a set of
+// options, as returned by GoBuildCommand.
+type BuildCommand struct {
+	// Dir is the directory go build runs in, or empty to run it in the
+	// current directory.
+	Dir string
+
+	// Args are the arguments to go build, starting with "build". The -o
+	// is the final output; GoCrossCompile builds into a temporary file
+	// next to it and moves that into place once the build succeeds.
+	Args []string
+
+	// Env are the variables that are set on top of the environment of
+	// gox, in order, so later ones take precedence. Unset are the names
+	// of the variables that are removed from it first, which is only
+	// done for reproducible builds.
+	Env   []string
+	Unset []string
+
+	// Output is the absolute path to the output.
+	Output string
+
+	// TemplateData is the data that the output template was rendered
+	// with.
+	TemplateData *OutputTemplateData
+
+	// Ldflags are the -ldflags that go build is given, which are those
+	// in the options with the link variables and any flags that gox adds
+	// itself, such as for reproducible builds.
+	Ldflags string
+}
+
+// environ returns the full environment to run the command with.
+func (c *BuildCommand) environ() []string {
+	unset := make(map[string]struct{}, len(c.Unset))
+	for _, key := range c.Unset {
+		unset[key] = struct{}{}
+	}
+
+	env := os.Environ()
+	result := make([]string, 0, len(env)+len(c.Env))
+	for _, kv := range env {
+		key := kv
+		if i := strings.Index(kv, "="); i >= 0 {
+			key = kv[:i]
+		}
+		if _, ok := unset[key]; !ok {
+			result = append(result, kv)
+		}
+	}
+
+	return append(result, c.Env...)
+}
+
+// GoBuildCommand returns the go build that GoCrossCompile would run for
+// the given options, without running it or writing anything. Like
+// GoCrossCompile, this resolves the C compiler for cgo builds in the
+// options.
+func GoBuildCommand(opts *CompileOpts) (*BuildCommand, error) {
+	cmd := &BuildCommand{}
+	if opts.Reproducible {
+		cmd.Unset = reproducibleUnset(os.Environ())
+		cmd.Env = append(cmd.Env, "TZ=UTC", "LC_ALL=C")
+	}
+	cmd.Env = append(cmd.Env,
+		"GOOS="+opts.Platform.OS,
+		"GOARCH="+opts.Platform.Arch)
+	if opts.Platform.Variant != "" {
+		cmd.Env = append(cmd.Env, variantEnv[opts.Platform.Arch]+"="+opts.Platform.Variant)
+	}
+
+	// If cgo is enabled then set that env var, along with the C toolchain
+	opts.Cgo = cgoEnabled(opts)
+	if opts.Cgo {
+		if err := ResolveCgo(opts); err != nil {
+			return nil, err
+		}
+
+		cmd.Env = append(cmd.Env, "CGO_ENABLED=1")
+		cmd.Env = append(cmd.Env, opts.CgoOpts.env()...)
+	} else {
+		cmd.Env = append(cmd.Env, "CGO_ENABLED=0")
+	}
+
+	cmd.Env = append(cmd.Env, opts.Env...)
+
+	if err := checkBuildMode(opts); err != nil {
+		return nil, err
+	}
+
+	// Determine the full path to the output so that we can change our
+	// working directory when executing go build.
+	var err error
+	cmd.TemplateData = NewOutputTemplateData(opts)
+	cmd.Output, err = outputPath(opts, cmd.TemplateData)
+	if err != nil {
+		return nil, err
+	}
+
+	// The link variables are added to the ldflags rather than replacing
+	// them, so they apply even if the ldflags are overridden.
+	ldflags := opts.Ldflags
+	if len(opts.LinkVars) > 0 {
+		x, err := linkVarFlags(opts.LinkVars, cmd.TemplateData)
+		if err != nil {
+			return nil, err
+		}
+
+		ldflags = strings.TrimSpace(ldflags + " " + x)
+	}
+
+	// Go prefixes the import directory with '_' when it is outside
+	// the GOPATH.For this, we just drop it since we move to that
+	// directory to build.
+	pkg := opts.PackagePath
+	if pkg[0] == '_' {
+		cmd.Dir = packageDir(pkg)
+		pkg = ""
+	}
+
+	args := []string{"build"}
+	if opts.Verbose {
+		args = append(args, "-v")
+	}
+	if opts.Rebuild {
+		args = append(args, "-a")
+	}
+	if opts.ModMode != "" {
+		args = append(args, "-mod", opts.ModMode)
+	}
+	if opts.Race {
+		args = append(args, "-race")
+	}
+	if opts.BuildMode != "" {
+		args = append(args, "-buildmode", opts.BuildMode)
+	}
+	if opts.Trimpath || opts.Reproducible {
+		args = append(args, "-trimpath")
+	}
+	if opts.Reproducible {
+		args = append(args, "-buildvcs=false")
+		ldflags = strings.TrimSpace(ldflags + " -buildid=")
+	}
+	cmd.Ldflags = ldflags
+	cmd.Args = append(args,
+		"-gcflags", opts.Gcflags,
+		"-ldflags", ldflags,
+		"-asmflags", opts.Asmflags,
+		"-tags", opts.Tags,
+		"-o", cmd.Output,
+		pkg)
+
+	return cmd, nil
+}
+
+// GoCrossCompileCached is like GoCrossCompile, but go build isn't run if
+// the cache shows that the output is already up to date, in which case
+// cached is true. The cache is updated after a successful build. With
+// Rebuild set in the options, the package is always built. The cache
+// may be nil, to always build without caching.
+func GoCrossCompileCached(ctx context.Context, opts *CompileOpts, cache *BuildCache) (output string, cached bool, err error) {
+	cmd, cached, err := goCrossCompileCached(ctx, opts, cache)
+	if err != nil {
+		return "", false, err
+	}
+
+	return cmd.Output, cached, nil
+}
+
+// goCrossCompileCached is GoCrossCompileCached, but returns the command
+// that was run, or would have been if the output wasn't up to date. The
+// command is returned with the error if it failed to run.
+func goCrossCompileCached(ctx context.Context, opts *CompileOpts, cache *BuildCache) (cmd *BuildCommand, cached bool, err error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	cmd, err = GoBuildCommand(opts)
+	if err != nil {
+		return nil, false, err
+	}
+	env := cmd.environ()
+	outputPathReal := cmd.Output
+
+	// Build into a temporary directory next to the output and move the
+	// result into place once the build succeeds. This way a failed or
+	// interrupted build never leaves a truncated binary behind, and the
+	// previous binary is kept. The directory is on the same filesystem as
+	// the output so that the rename is atomic.
+	outputDir := filepath.Dir(outputPathReal)
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return cmd, false, err
+	}
+	tempDir, err := newTempDir(outputDir, ".gox-")
+	if err != nil {
+		return cmd, false, err
+	}
+	defer removeTempDir(tempDir)
+	tempPath := filepath.Join(tempDir, filepath.Base(outputPathReal))
+
+	args := append([]string{}, cmd.Args...)
+	args[len(args)-2] = tempPath
+
+	// In verbose mode, the output of the build is streamed to stdout as
+	// it happens, so that slow builds can be followed.
+	var stream io.Writer
+	if opts.Verbose {
+		stdout := opts.Stdout
+		if stdout == nil {
+			stdout = os.Stdout
+		}
+
+		w, done := streamLines(stdout, opts.Platform.String())
+		defer done()
+		stream = w
+	}
+
+	// The key is computed from the command that would run, so anything
+	// that changes the build changes the key. If it can't be computed,
+	// such as when go list fails, the package is built without caching
+	// and go build reports the problem.
+	var key string
+	if cache != nil {
+		key, _ = cacheKey(ctx, opts.GoCmd, env, cmd.Dir, cmd.Args)
+		if key != "" && !opts.Rebuild && cache.Fresh(outputPathReal, key) {
+			return cmd, true, nil
+		}
+	}
+
+	if _, err := execGoStream(ctx, opts.GoCmd, env, cmd.Dir, stream, args...); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return cmd, false, &TimeoutError{Timeout: opts.Timeout}
+		}
+
+		return cmd, false, err
+	}
+
+	if err := os.Rename(tempPath, outputPathReal); err != nil {
+		return cmd, false, err
+	}
+
+	// Library build modes also write a C header, which goes next to the
+	// output.
+	if header := headerPath(tempPath, opts.BuildMode); header != "" {
+		if _, err := os.Stat(header); err == nil {
+			if err := os.Rename(header, headerPath(outputPathReal, opts.BuildMode)); err != nil {
+				return cmd, false, err
+			}
+		}
+	}
+
+	if key != "" {
+		files := []string{outputPathReal}
+		if header := headerPath(outputPathReal, opts.BuildMode); header != "" {
+			if _, err := os.Stat(header); err == nil {
+				files = append(files, header)
+			}
+		}
+		if err := cache.Put(outputPathReal, key, files); err != nil {
+			return cmd, false, err
+		}
+	}
+
+	return cmd, false, nil
+}
+
+// outputPath renders the output template of the options with the data
+// and returns the absolute path to the output. The extension is only
+// added if the template didn't already add it, such as with {{.Ext}}.
+func outputPath(opts *CompileOpts, data *OutputTemplateData) (string, error) {
+	path, err := renderTemplate(opts.OutputTpl, data)
+	if err != nil {
+		return "", err
+	}
+
+	if !opts.NoExt && !strings.HasSuffix(path, data.Ext) {
+		path += data.Ext
+	}
+
+	return filepath.Abs(path)
+}
+
+// cgoEnabled returns true if cgo is enabled for the build. If we're
+// building for our own platform, then cgo is always enabled. We respect
+// the CGO_ENABLED flag if that is explicitly set on the platform.
+func cgoEnabled(opts *CompileOpts) bool {
+	if opts.Cgo {
+		return true
+	}
+	if os.Getenv("CGO_ENABLED") == "0" || lookupEnv(opts.Env, "CGO_ENABLED") == "0" {
+		return false
+	}
+
+	return runtime.GOOS == opts.Platform.OS && runtime.GOARCH == opts.Platform.Arch
+}
+
+// GoPackageExcluded returns true if the build constraints of the package
+// in the options, such as "//go:build linux", exclude all of its Go files
+// on the platform, so that it can't be built for it.
+func GoPackageExcluded(ctx context.Context, opts *CompileOpts) (bool, error) {
+	env := append(os.Environ(),
+		"GOOS="+opts.Platform.OS,
+		"GOARCH="+opts.Platform.Arch)
+	if opts.Platform.Variant != "" {
+		env = append(env, variantEnv[opts.Platform.Arch]+"="+opts.Platform.Variant)
+	}
+	if cgoEnabled(opts) {
+		env = append(env, "CGO_ENABLED=1")
+	} else {
+		env = append(env, "CGO_ENABLED=0")
+	}
+	env = append(env, opts.Env...)
+
+	chdir, pkg := "", opts.PackagePath
+	if pkg[0] == '_' {
+		chdir, pkg = packageDir(pkg), "."
+	}
+
+	args := []string{"list", "-e", "-f", "{{len .GoFiles}} {{len .CgoFiles}} {{len .IgnoredGoFiles}}"}
+	if opts.ModMode != "" {
+		args = append(args, "-mod", opts.ModMode)
+	}
+	args = append(args, "-tags", opts.Tags, pkg)
+	output, err := execGo(ctx, opts.GoCmd, env, chdir, args...)
+	if err != nil {
+		return false, err
+	}
+
+	var goFiles, cgoFiles, ignored int
+	if _, err := fmt.Sscanf(output, "%d %d %d", &goFiles, &cgoFiles, &ignored); err != nil {
+		return false, fmt.Errorf("unexpected output from go list: %s", output)
+	}
+
+	return goFiles+cgoFiles == 0 && ignored > 0, nil
+}
+
+// lookupEnv returns the value of the last assignment to key in env, which
+// is the one that takes effect.
+func lookupEnv(env []string, key string) string {
+	for i := len(env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(env[i], key+"=") {
+			return env[i][len(key)+1:]
+		}
+	}
+
+	return ""
+}
+
+// binaryExt returns the extension of the file that go build produces for
+// the platform with the given -buildmode.
+func binaryExt(platform Platform, buildMode string) string {
+	switch buildMode {
+	case "c-shared":
+		switch platform.OS {
+		case "windows":
+			return ".dll"
+		case "darwin", "ios":
+			return ".dylib"
+		default:
+			return ".so"
+		}
+	case "shared", "plugin":
+		return ".so"
+	case "c-archive", "archive":
+		return ".a"
+	}
+
+	switch {
+	case platform.Arch == "wasm":
+		return ".wasm"
+	case platform.OS == "windows":
+		return ".exe"
+	default:
+		return ""
+	}
+}
+
+// packageDir returns the directory of a package outside of GOPATH, which
+// Go names with its path prefixed by '_'.
+func packageDir(path string) string {
+	if runtime.GOOS != "windows" {
+		return path[1:]
+	}
+
+	// We have to replace weird paths like this:
+	//
+	//   _/c_/Users
+	//
+	// With:
+	//
+	//   c:\Users
+	//
+	re := regexp.MustCompile("^/([a-zA-Z])_/")
+	dir := re.ReplaceAllString(path[1:], "$1:\\")
+	return strings.Replace(dir, "/", "\\", -1)
+}
+
+// GoMainDirs returns the file paths to the packages that are "main"
+// packages, from the list of packages given. The list of packages can
+// include relative paths, the special "..." Go keyword, etc. Use
+// GoMainPackages to also get the directives of each package. If any of
+// the packages couldn't be loaded, the others are still returned along
+// with a *PackageLoadError.
+func GoMainDirs(packages []string, GoCmd string) ([]string, error) {
+	mains, err := GoMainPackages(packages, GoCmd)
+	if _, ok := err.(*PackageLoadError); err != nil && !ok {
+		return nil, err
+	}
+
+	results := make([]string, len(mains))
+	for i, main := range mains {
+		results[i] = main.ImportPath
+	}
+
+	return results, err
+}
+
+// BrokenPackage is a package that GoMainPackages couldn't load.
+type BrokenPackage struct {
+	ImportPath string `json:"package"`
+	Err        string `json:"error"`
+}
+
+// PackageLoadError is returned by GoMainPackages, along with the main
+// packages that could be loaded, when some of the packages couldn't be.
+type PackageLoadError struct {
+	Packages []*BrokenPackage
+}
+
+func (e *PackageLoadError) Error() string {
+	lines := make([]string, len(e.Packages))
+	for i, p := range e.Packages {
+		lines[i] = p.ImportPath + ": " + p.Err
+	}
+
+	return fmt.Sprintf("%d package(s) could not be loaded:\n%s",
+		len(e.Packages), strings.Join(lines, "\n"))
+}
+
+// GoMainPackages is like GoMainDirs, but also reads the //gox: directives
+// from the source of each package, which may declare the platforms it
+// is built for. See ParseDirectives.
+//
+// A package that can't be loaded, because go list reports an error for
+// it or one of its imports or because its directives are invalid, doesn't
+// stop the others from being returned. Those packages are listed in a
+// *PackageLoadError instead. Packages that aren't main packages are
+// only listed if go list couldn't tell what they are.
+func GoMainPackages(packages []string, GoCmd string) ([]*MainPackage, error) {
+	args := make([]string, 0, len(packages)+3)
+	args = append(args, "list", "-e", "-json")
+	args = append(args, packages...)
+
+	output, err := execGo(context.Background(), GoCmd, nil, "", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*MainPackage
+	var broken []*BrokenPackage
+	dec := json.NewDecoder(strings.NewReader(output))
+	for {
+		var p listPackage
+		if err := dec.Decode(&p); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("error reading go list output: %s", err)
+		}
+
+		// A package whose files are all for other platforms can still be
+		// built for those, so it isn't broken.
+		if p.Error != nil && strings.Contains(p.Error.Err, "build constraints exclude all Go files") {
+			if name := packageName(p.Dir, p.IgnoredGoFiles); name != "" {
+				p.Name, p.Error = name, nil
+			}
+		}
+
+		if p.Name != "main" && (p.Name != "" || p.Error == nil) {
+			continue
+		}
+		if err := p.err(); err != "" {
+			broken = append(broken, &BrokenPackage{ImportPath: p.ImportPath, Err: err})
+			continue
+		}
+
+		// The directives are read from the files for every platform, not
+		// just the ones for the host.
+		var files []string
+		for _, list := range [][]string{p.GoFiles, p.CgoFiles, p.IgnoredGoFiles} {
+			for _, name := range list {
+				files = append(files, filepath.Join(p.Dir, name))
+			}
+		}
+
+		directives, err := ParseDirectives(files)
+		if err != nil {
+			broken = append(broken, &BrokenPackage{ImportPath: p.ImportPath, Err: err.Error()})
+			continue
+		}
+
+		results = append(results, &MainPackage{
+			ImportPath: p.ImportPath,
+			Dir:        p.Dir,
+			Directives: directives,
+		})
+	}
+
+	if len(broken) > 0 {
+		return results, &PackageLoadError{Packages: broken}
+	}
+
+	return results, nil
+}
+
+// packageName returns the package name in the first of the files in dir
+// that has a valid package clause, or an empty string if none do.
+func packageName(dir string, files []string) string {
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.PackageClauseOnly)
+		if err == nil {
+			return f.Name.Name
+		}
+	}
+
+	return ""
+}
+
+// GoDistList returns the platforms supported by the toolchain behind
+// GoCmd, as reported by `go tool dist list -json`. Toolchains older than
+// Go 1.10 don't support the -json flag and will return an error.
+func GoDistList(GoCmd string) ([]Platform, error) {
+	output, err := execGo(context.Background(), GoCmd, nil, "", "tool", "dist", "list", "-json")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseDistList([]byte(output))
+}
+
+// GoRoot returns the GOROOT value for the compiled `go` binary.
+func GoRoot() (string, error) {
+	return GoEnv("go", "GOROOT")
+}
+
+// GoEnv returns the value of a single `go env` variable for GoCmd.
+func GoEnv(GoCmd string, key string) (string, error) {
+	output, err := execGo(context.Background(), GoCmd, nil, "", "env", key)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(output), nil
+}
+
+// GoVersion reads the version of `go` that is on the PATH. This is done
+// instead of `runtime.Version()` because it is possible to run gox against
+// another Go version.
+func GoVersion() (string, error) {
+	// NOTE: We use `go run` instead of `go version` because the output
+	// of `go version` might change whereas the source is guaranteed to run
+	// for some time thanks to Go's compatibility guarantee.
+
+	td, err := ioutil.TempDir("", "gox")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(td)
+
+	// Write the source code for the program that will generate the version
+	sourcePath := filepath.Join(td, "version.go")
+	if err := ioutil.WriteFile(sourcePath, []byte(versionSource), 0644); err != nil {
+		return "", err
+	}
+
+	// Execute and read the version, which will be the only thing on stdout.
+	return execGo(context.Background(), "go", nil, "", "run", sourcePath)
+}
+
+// GoVersionParts parses the version numbers from the version itself
+// into major and minor: 1.5, 1.4, etc.
+func GoVersionParts() (result [2]int, err error) {
+	version, err := GoVersion()
+	if err != nil {
+		return
+	}
+
+	_, err = fmt.Sscanf(version, "go%d.%d", &result[0], &result[1])
+	return
+}
+
+func execGo(ctx context.Context, GoCmd string, env []string, dir string, args ...string) (string, error) {
+	return execGoStream(ctx, GoCmd, env, dir, nil, args...)
+}
+
+// execGoStream is like execGo, but if stream is non-nil then all output
+// from the command is also written to it as it happens.
+//
+// The command is run in its own process group. If the context is
+// cancelled, the whole group is terminated so that none of the compiler
+// and linker processes it started are left running. If the group doesn't
+// exit within killDelay, it is killed.
+func execGoStream(ctx context.Context, GoCmd string, env []string, dir string, stream io.Writer, args ...string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	var stderr, stdout bytes.Buffer
+	cmd := exec.Command(GoCmd, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if stream != nil {
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, stream)
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, stream)
+	}
+	if env != nil {
+		cmd.Env = env
+	}
+	if dir != "" {
+		cmd.Dir = dir
+	}
+	setProcessGroup(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return "", &ExecError{Err: err}
+	}
+	running.add(cmd)
+	defer running.remove(cmd)
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	go func() {
+		select {
+		case <-ctx.Done():
+			terminateProcessGroup(cmd)
+		case <-doneCh:
+			return
+		}
+
+		select {
+		case <-time.After(killDelay):
+			killProcessGroup(cmd)
+		case <-doneCh:
+		}
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		return "", &ExecError{Err: err, Stderr: stderr.String()}
+	}
+
+	return stdout.String(), nil
+}
+
+// killDelay is how long a cancelled go command has to exit before it
+// is killed.
+const killDelay = 5 * time.Second
+
+// running tracks the go commands that are currently running so that
+// they can all be killed at once.
+var running = &runningCmds{cmds: make(map[*exec.Cmd]struct{})}
+
+type runningCmds struct {
+	sync.Mutex
+	cmds map[*exec.Cmd]struct{}
+}
+
+func (r *runningCmds) add(cmd *exec.Cmd) {
+	r.Lock()
+	defer r.Unlock()
+	r.cmds[cmd] = struct{}{}
+}
+
+func (r *runningCmds) remove(cmd *exec.Cmd) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.cmds, cmd)
+}
+
+// KillAll forcibly kills the process groups of every go command that gox
+// is running, and removes the temporary directories of the builds in
+// progress. This is meant for when gox has to exit immediately, since
+// the process groups won't receive the signal that gox did and the
+// builds won't get to clean up after themselves.
+func KillAll() {
+	running.killAll()
+	tempDirs.removeAll()
+}
+
+func (r *runningCmds) killAll() {
+	r.Lock()
+	defer r.Unlock()
+	for cmd := range r.cmds {
+		killProcessGroup(cmd)
+	}
+}
+
+// tempDirs tracks the temporary directories that builds in progress are
+// writing to, so that they can be removed if gox has to exit.
+var tempDirs = &tempDirSet{dirs: make(map[string]struct{})}
+
+type tempDirSet struct {
+	sync.Mutex
+	dirs map[string]struct{}
+}
+
+// newTempDir creates a temporary directory like ioutil.TempDir and tracks
+// it until it is removed with removeTempDir.
+func newTempDir(dir, pattern string) (string, error) {
+	tempDirs.Lock()
+	defer tempDirs.Unlock()
+	name, err := ioutil.TempDir(dir, pattern)
+	if err != nil {
+		return "", err
+	}
+
+	tempDirs.dirs[name] = struct{}{}
+	return name, nil
+}
+
+// removeTempDir removes a directory created with newTempDir.
+func removeTempDir(name string) {
+	tempDirs.Lock()
+	defer tempDirs.Unlock()
+	os.RemoveAll(name)
+	delete(tempDirs.dirs, name)
+}
+
+// removeAll removes every tracked directory.
+func (t *tempDirSet) removeAll() {
+	t.Lock()
+	defer t.Unlock()
+	for name := range t.dirs {
+		os.RemoveAll(name)
+		delete(t.dirs, name)
+	}
+}
+
+// ExecError is the error returned when the go command fails. It keeps
+// the output on stderr separate so that it can be reported on its own.
+type ExecError struct {
+	Err    error
+	Stderr string
+}
+
+func (e *ExecError) Error() string {
+	return fmt.Sprintf("%s\nStderr: %s", e.Err, e.Stderr)
+}
+
+// ExitStatus returns the exit status of the go command, or -1 if it
+// didn't exit normally.
+func (e *ExecError) ExitStatus() int {
+	if exitErr, ok := e.Err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+
+	return -1
+}
+
+const versionSource = `package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func main() {
+	fmt.Print(runtime.Version())
+}`
@@ -0,0 +1,340 @@
+package gox
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGoVersion(t *testing.T) {
+	v, err := GoVersion()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	acceptable := []string{
+		"devel",
+		"go1.0",
+		"go1.1",
+		"go1.2",
+		"go1.3",
+		"go1.4",
+		"go1.5",
+		"go1.6",
+		"go1.7",
+		"go1.8",
+		"go1.9",
+		"go1.10",
+		"go1.11",
+		"go1.12",
+		"go1.13",
+		"go1.14",
+		"go1.15",
+		"go1.16",
+		"go1.17",
+		"go1.18",
+	}
+	found := false
+	for _, expected := range acceptable {
+		if strings.HasPrefix(v, expected) {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		t.Fatalf("bad: %#v", v)
+	}
+}
+
+func TestGoDistList(t *testing.T) {
+	ps, err := GoDistList("go")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	found := false
+	for _, p := range ps {
+		if p.OS == "linux" && p.Arch == "amd64" {
+			found = true
+			if !p.Default {
+				t.Fatalf("linux/amd64 should be default: %#v", p)
+			}
+		}
+	}
+
+	if !found {
+		t.Fatalf("linux/amd64 not found: %#v", ps)
+	}
+}
+
+func TestExecGoStream_cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := execGoStream(ctx, "go", nil, "", nil, "version"); err == nil {
+		t.Fatal("should err")
+	}
+}
+
+func TestKillAll_removesTempDirs(t *testing.T) {
+	td, err := ioutil.TempDir("", "gox")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	dir, err := newTempDir(td, ".gox-")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	KillAll()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("temp dir should be removed: %s", err)
+	}
+
+	// Removing it again once the build notices is fine
+	removeTempDir(dir)
+}
+
+func TestGoCrossCompile_keepsOutputOnFailure(t *testing.T) {
+	td := testModule(t, map[string]string{
+		"main.go": "package main\n\nfunc main() { undefined() }\n",
+	})
+
+	// A binary from a previous, successful build
+	output := filepath.Join(td, "dist", "foo")
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := ioutil.WriteFile(output, []byte("previous"), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	opts := &CompileOpts{
+		PackagePath: "_" + filepath.ToSlash(td),
+		Platform:    Platform{OS: runtime.GOOS, Arch: runtime.GOARCH},
+		OutputTpl:   output,
+		GoCmd:       "go",
+	}
+	if _, err := GoCrossCompile(context.Background(), opts); err == nil {
+		t.Fatal("should err")
+	}
+
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(data) != "previous" {
+		t.Fatalf("output should be kept: %q", data)
+	}
+
+	entries, err := ioutil.ReadDir(filepath.Dir(output))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("temporary files left behind: %d entries", len(entries))
+	}
+}
+
+func TestGoCrossCompile_timeout(t *testing.T) {
+	opts := &CompileOpts{
+		PackagePath: "github.com/mitchellh/gox",
+		Platform:    Platform{OS: runtime.GOOS, Arch: runtime.GOARCH},
+		OutputTpl:   filepath.Join(t.TempDir(), "gox"),
+		GoCmd:       "go",
+		Timeout:     time.Nanosecond,
+	}
+
+	_, err := GoCrossCompile(context.Background(), opts)
+	if _, ok := err.(*TimeoutError); !ok {
+		t.Fatalf("bad: %#v", err)
+	}
+}
+
+func TestBinaryExt(t *testing.T) {
+	cases := []struct {
+		Platform  Platform
+		BuildMode string
+		Ext       string
+	}{
+		{Platform{OS: "linux", Arch: "amd64"}, "", ""},
+		{Platform{OS: "windows", Arch: "amd64"}, "", ".exe"},
+		{Platform{OS: "windows", Arch: "amd64"}, "pie", ".exe"},
+		{Platform{OS: "js", Arch: "wasm"}, "", ".wasm"},
+		{Platform{OS: "windows", Arch: "amd64"}, "c-shared", ".dll"},
+		{Platform{OS: "darwin", Arch: "arm64"}, "c-shared", ".dylib"},
+		{Platform{OS: "linux", Arch: "arm"}, "c-shared", ".so"},
+		{Platform{OS: "linux", Arch: "amd64"}, "plugin", ".so"},
+		{Platform{OS: "windows", Arch: "386"}, "c-archive", ".a"},
+	}
+
+	for _, tc := range cases {
+		if ext := binaryExt(tc.Platform, tc.BuildMode); ext != tc.Ext {
+			t.Fatalf("%s %s: bad: %q", tc.Platform.String(), tc.BuildMode, ext)
+		}
+	}
+}
+
+func TestGoCrossCompile_ext(t *testing.T) {
+	td := testModule(t, map[string]string{
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+
+	cases := []struct {
+		OutputTpl string
+		NoExt     bool
+		Output    string
+	}{
+		{"foo", false, "foo.exe"},
+		{"foo{{.Ext}}", false, "foo.exe"},
+		{"foo.exe", false, "foo.exe"},
+		{"foo", true, "foo"},
+	}
+
+	for _, tc := range cases {
+		opts := &CompileOpts{
+			PackagePath: "_" + filepath.ToSlash(td),
+			Platform:    Platform{OS: "windows", Arch: "amd64"},
+			OutputTpl:   filepath.Join(td, "dist", tc.OutputTpl),
+			NoExt:       tc.NoExt,
+			GoCmd:       "go",
+		}
+		output, err := GoCrossCompile(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if output != filepath.Join(td, "dist", tc.Output) {
+			t.Fatalf("%s: bad: %s", tc.OutputTpl, output)
+		}
+	}
+}
+
+func TestGoCrossCompile_variant(t *testing.T) {
+	td := testModule(t, map[string]string{
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+
+	opts := &CompileOpts{
+		PackagePath: "_" + filepath.ToSlash(td),
+		Platform:    Platform{OS: "linux", Arch: "arm", Variant: "6"},
+		OutputTpl:   filepath.Join(td, "foo_{{.OS}}_{{.Arch}}v{{.Variant}}"),
+		GoCmd:       "go",
+	}
+	output, err := GoCrossCompile(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if filepath.Base(output) != "foo_linux_armv6" {
+		t.Fatalf("bad: %s", output)
+	}
+
+	info, err := execGo(context.Background(), "go", nil, "", "version", "-m", output)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(info, "GOARM=6") {
+		t.Fatalf("GOARM should be set:\n%s", info)
+	}
+}
+
+func TestGoPackageExcluded(t *testing.T) {
+	td := testModule(t, map[string]string{
+		"main.go":       "//go:build linux || darwin || anywhere\n\npackage main\n\nfunc main() {}\n",
+		"main_linux.go": "package main\n\nconst name = \"linux\"\n",
+		"cgo.go":        "//go:build windows\n\npackage main\n\nimport \"C\"\n\nfunc main() {}\n",
+	})
+
+	cases := []struct {
+		Platform Platform
+		Tags     string
+		Cgo      bool
+		Expected bool
+	}{
+		{Platform{OS: "linux", Arch: "amd64"}, "", false, false},
+		{Platform{OS: "darwin", Arch: "arm64"}, "", false, false},
+		{Platform{OS: "plan9", Arch: "amd64"}, "", false, true},
+		{Platform{OS: "plan9", Arch: "amd64"}, "anywhere", false, false},
+		{Platform{OS: "windows", Arch: "amd64"}, "", false, true},
+		{Platform{OS: "windows", Arch: "amd64"}, "", true, false},
+	}
+
+	for _, tc := range cases {
+		opts := &CompileOpts{
+			PackagePath: "_" + filepath.ToSlash(td),
+			Platform:    tc.Platform,
+			Tags:        tc.Tags,
+			Cgo:         tc.Cgo,
+			GoCmd:       "go",
+		}
+		actual, err := GoPackageExcluded(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if actual != tc.Expected {
+			t.Fatalf("%#v: bad: %v", tc, actual)
+		}
+	}
+}
+
+func TestGoMainPackages(t *testing.T) {
+	td := testModuleDir(t, map[string]string{
+		"ok/main.go":         "//gox:platforms linux/*\npackage main\n\nfunc main() {}\n",
+		"lib/lib.go":         "package lib\n",
+		"windows/main.go":    "//go:build windows\n\npackage main\n\nfunc main() {}\n",
+		"brokenimp/main.go":  "package main\n\nimport \"nope/nope\"\n\nfunc main() { nope.Nope() }\n",
+		"badsyntax/main.go":  "package main\n\nimport \"fmt\n",
+		"baddirective/x.go":  "//gox:platforms linux\npackage main\n\nfunc main() {}\n",
+		"brokenlib/main.go":  "package main\n\nimport _ \"example.com/foo/brokenlib/lib\"\n\nfunc main() {}\n",
+		"brokenlib/lib/a.go": "package lib\n\nimport \"nope/nope\"\n",
+	})
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(td); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	mains, err := GoMainPackages([]string{"./...", "./windows", "./missing"}, "go")
+	loadErr, ok := err.(*PackageLoadError)
+	if !ok {
+		t.Fatalf("err: %s", err)
+	}
+
+	var paths []string
+	for _, main := range mains {
+		paths = append(paths, main.ImportPath)
+	}
+	expected := []string{"example.com/foo/ok", "example.com/foo/windows"}
+	if strings.Join(paths, " ") != strings.Join(expected, " ") {
+		t.Fatalf("bad: %#v", paths)
+	}
+	if len(mains[0].Directives.Platforms) != 1 {
+		t.Fatalf("bad: %#v", mains[0].Directives)
+	}
+
+	var broken []string
+	for _, p := range loadErr.Packages {
+		broken = append(broken, p.ImportPath)
+	}
+	expected = []string{
+		"example.com/foo/baddirective",
+		"example.com/foo/badsyntax",
+		"example.com/foo/brokenimp",
+		"example.com/foo/brokenlib",
+		"./missing",
+	}
+	if strings.Join(broken, " ") != strings.Join(expected, " ") {
+		t.Fatalf("bad: %#v", broken)
+	}
+}
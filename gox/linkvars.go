@@ -0,0 +1,65 @@
+package gox
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidateLinkVar returns an error if v isn't a valid link variable for
+// CompileOpts.LinkVars: importpath.name=value, where the value is an
+// output template.
+func ValidateLinkVar(v string) error {
+	i := strings.Index(v, "=")
+	if i <= 0 {
+		return fmt.Errorf("invalid -X %q: should be importpath.name=value", v)
+	}
+
+	if _, err := ParseOutputTemplate(v[i+1:]); err != nil {
+		return fmt.Errorf("invalid -X %q: %s", v, err)
+	}
+
+	return nil
+}
+
+// linkVarFlags renders the values of the link variables with the template
+// data and returns them as -X flags for -ldflags, quoted the way go build
+// splits them.
+func linkVarFlags(vars []string, data *OutputTemplateData) (string, error) {
+	result := make([]string, 0, len(vars))
+	for _, v := range vars {
+		if err := ValidateLinkVar(v); err != nil {
+			return "", err
+		}
+
+		i := strings.Index(v, "=")
+		value, err := renderTemplate(v[i+1:], data)
+		if err != nil {
+			return "", fmt.Errorf("-X %s: %s", v[:i], err)
+		}
+
+		arg, err := quoteLdflag(v[:i+1] + value)
+		if err != nil {
+			return "", err
+		}
+
+		result = append(result, "-X", arg)
+	}
+
+	return strings.Join(result, " "), nil
+}
+
+// quoteLdflag quotes a single argument for -ldflags. Go splits the flags
+// on spaces, and allows arguments to be wrapped in single or double
+// quotes, but has no escapes, so a value with both can't be passed.
+func quoteLdflag(arg string) (string, error) {
+	switch {
+	case arg != "" && !strings.ContainsAny(arg, " \t\n\r'\""):
+		return arg, nil
+	case !strings.Contains(arg, "'"):
+		return "'" + arg + "'", nil
+	case !strings.Contains(arg, `"`):
+		return `"` + arg + `"`, nil
+	default:
+		return "", fmt.Errorf("-X %s: can't contain both single and double quotes", arg)
+	}
+}
@@ -0,0 +1,73 @@
+package gox
+
+import (
+	"context"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestLinkVarFlags(t *testing.T) {
+	data := &OutputTemplateData{OS: "linux", Arch: "arm", Version: "1.2.0"}
+	cases := []struct {
+		Vars     []string
+		Expected string
+		Err      bool
+	}{
+		{nil, "", false},
+		{[]string{"main.version={{.Version}}"}, "-X main.version=1.2.0", false},
+		{
+			[]string{"main.platform={{.OS}}/{{.Arch}}", "main.name=Hello World"},
+			"-X main.platform=linux/arm -X 'main.name=Hello World'",
+			false,
+		},
+		{[]string{"main.name=it's"}, `-X "main.name=it's"`, false},
+		{[]string{"main.empty="}, "-X main.empty=", false},
+		{[]string{`main.name='"`}, "", true},
+		{[]string{"main.version"}, "", true},
+		{[]string{"main.version={{.Nope}}"}, "", true},
+	}
+
+	for _, tc := range cases {
+		actual, err := linkVarFlags(tc.Vars, data)
+		if (err != nil) != tc.Err {
+			t.Fatalf("%#v: err: %v", tc.Vars, err)
+		}
+		if actual != tc.Expected {
+			t.Fatalf("%#v: bad: %s", tc.Vars, actual)
+		}
+	}
+}
+
+func TestGoCrossCompile_linkVars(t *testing.T) {
+	td := testModule(t, map[string]string{
+		"main.go": "package main\n\nimport \"fmt\"\n\n" +
+			"var version, platform string\n\n" +
+			"func main() { fmt.Print(version + \" \" + platform) }\n",
+	})
+
+	opts := &CompileOpts{
+		PackagePath: "_" + filepath.ToSlash(td),
+		Platform:    Platform{OS: runtime.GOOS, Arch: runtime.GOARCH},
+		OutputTpl:   filepath.Join(td, "foo"),
+		Ldflags:     "-s -w",
+		GoCmd:       "go",
+		Version:     "1.2.0",
+		LinkVars: []string{
+			"main.version=v{{.Version}}",
+			"main.platform={{.OS}}/{{.Arch}}",
+		},
+	}
+	output, err := GoCrossCompile(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	actual, err := execGo(context.Background(), output, nil, "")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if expected := "v1.2.0 " + runtime.GOOS + "/" + runtime.GOARCH; actual != expected {
+		t.Fatalf("bad: %s", actual)
+	}
+}
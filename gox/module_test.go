@@ -0,0 +1,53 @@
+package gox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// testModule creates a module with the given files in a temporary
+// directory and returns the directory, for tests that build it with the
+// "_" + filepath.ToSlash(dir) package path. That syntax differs on
+// Windows, so the test is skipped there.
+func testModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the package path syntax differs on windows")
+	}
+
+	return testModuleDir(t, files)
+}
+
+// testModuleDir is like testModule, for tests that don't use the package
+// path. The files are keyed by slash-separated paths, and a go.mod for
+// example.com/foo is added unless one is given.
+func testModuleDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	td, err := ioutil.TempDir("", "gox")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(td) })
+
+	write := func(name, contents string) {
+		path := filepath.Join(td, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	if _, ok := files["go.mod"]; !ok {
+		write("go.mod", "module example.com/foo\n")
+	}
+	for name, contents := range files {
+		write(name, contents)
+	}
+
+	return td
+}
@@ -0,0 +1,45 @@
+package gox
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/mitchellh/iochan"
+)
+
+// outputLock serializes writes of output so that output from builds
+// running in parallel never interleaves within a line.
+var outputLock sync.Mutex
+
+// printf writes to w while holding outputLock.
+func printf(w io.Writer, format string, args ...interface{}) {
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	fmt.Fprintf(w, format, args...)
+}
+
+// streamLines returns a writer that prints everything written to it to w
+// a line at a time, with each line prefixed. The returned func must be
+// called when writing is done. It waits until all of the output has been
+// printed.
+func streamLines(w io.Writer, prefix string) (io.Writer, func()) {
+	pr, pw := io.Pipe()
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		for line := range iochan.DelimReader(pr, '\n') {
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+
+			printf(w, "%s: %s", prefix, line)
+		}
+	}()
+
+	return pw, func() {
+		pw.Close()
+		<-doneCh
+	}
+}
@@ -0,0 +1,67 @@
+package gox
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestStreamLines(t *testing.T) {
+	var buf syncBuffer
+
+	// Write partial lines from many streams at once. Every line that is
+	// printed must be whole.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w, done := streamLines(&buf, fmt.Sprintf("s%d", i))
+			for j := 0; j < 50; j++ {
+				fmt.Fprintf(w, "line %d-", i)
+				fmt.Fprintf(w, "%d\n", j)
+			}
+			fmt.Fprintf(w, "last %d", i)
+			done()
+		}(i)
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 10*51 {
+		t.Fatalf("bad line count: %d", len(lines))
+	}
+	for _, line := range lines {
+		var prefix, i, j int
+		if _, err := fmt.Sscanf(line, "s%d: line %d-%d", &prefix, &i, &j); err == nil {
+			if prefix != i {
+				t.Fatalf("interleaved: %q", line)
+			}
+			continue
+		}
+		if _, err := fmt.Sscanf(line, "s%d: last %d", &prefix, &i); err != nil || prefix != i {
+			t.Fatalf("bad line: %q", line)
+		}
+	}
+}
+
+// syncBuffer is a bytes.Buffer that is safe for concurrent use, without
+// relying on the locking done by printf.
+type syncBuffer struct {
+	sync.Mutex
+	bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.String()
+}
@@ -0,0 +1,187 @@
+package gox
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// Plan is what Builder.Plan found would be done to build one package for
+// one platform.
+type Plan struct {
+	Package  string
+	Platform Platform
+
+	// Opts are the options the package would be built with, after
+	// Override.
+	Opts CompileOpts
+
+	// Command is the go build that would run, or nil if the build is
+	// skipped or would fail before it runs.
+	Command *BuildCommand
+
+	// Archive is the path to the archive, if Builder.Archive is set.
+	Archive string
+
+	// Skipped and SkipReason are set as they would be in the Result.
+	Skipped    bool
+	SkipReason string
+
+	// Err is the reason the build would fail before go build runs, such
+	// as an error from Override or a missing C compiler.
+	Err error
+}
+
+// Plan works out what Build would do for every package and platform,
+// without building anything, and returns the plans sorted like the
+// results of Build. Nothing is written, but go list is run to find the
+// details of each package.
+func (b *Builder) Plan(ctx context.Context) ([]*Plan, error) {
+	opts, err := b.baseOpts()
+	if err != nil {
+		return nil, err
+	}
+
+	var plans []*Plan
+	for _, job := range b.jobs() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		plans = append(plans, b.plan(ctx, opts, job.path, job.platform))
+	}
+
+	sort.Slice(plans, func(i, j int) bool {
+		a, b := plans[i], plans[j]
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+
+		return a.Platform.String() < b.Platform.String()
+	})
+
+	return plans, nil
+}
+
+// plan works out what build would do for a single package and platform.
+func (b *Builder) plan(ctx context.Context, opts CompileOpts, path string, platform Platform) *Plan {
+	plan := &Plan{
+		Package:  path,
+		Platform: platform,
+	}
+
+	plan.Opts, plan.Err = b.jobOpts(opts, path, platform)
+	if plan.Err != nil {
+		return plan
+	}
+
+	if plan.SkipReason = b.skipReason(ctx, &plan.Opts); plan.SkipReason != "" {
+		plan.Skipped = true
+		return plan
+	}
+
+	if plan.Err = ResolveCgo(&plan.Opts); plan.Err != nil {
+		return plan
+	}
+
+	// GoBuildCommand modifies the options it is given, like
+	// GoCrossCompile does.
+	opts = plan.Opts
+	plan.Command, plan.Err = GoBuildCommand(&opts)
+	if plan.Err != nil {
+		return plan
+	}
+
+	if b.Archive != nil {
+		archive := *b.Archive
+		archive.PackagePath = path
+		archive.Platform = platform
+		archive.TemplateData = plan.Command.TemplateData
+		format, err := archiveFormat(&archive)
+		if err != nil {
+			plan.Err = err
+			return plan
+		}
+
+		plan.Archive, plan.Err = archivePath(&archive, format)
+	}
+
+	return plan
+}
+
+// PlanReport is the machine-readable form of the plans from
+// Builder.Plan, as written by -dry-run-format=json.
+type PlanReport struct {
+	GoVersion string     `json:"go_version"`
+	Jobs      []*JobPlan `json:"jobs"`
+
+	// BrokenPackages are the packages that couldn't be loaded, and so
+	// wouldn't be built. See PackageLoadError.
+	BrokenPackages []*BrokenPackage `json:"broken_packages,omitempty"`
+}
+
+// JobPlan is the plan for building a single package for a single
+// platform. Env only has the variables that gox sets, and Unset the ones
+// it removes; the rest of the environment is inherited.
+type JobPlan struct {
+	Package    string   `json:"package"`
+	OS         string   `json:"os"`
+	Arch       string   `json:"arch"`
+	Variant    string   `json:"variant,omitempty"`
+	Output     string   `json:"output,omitempty"`
+	Header     string   `json:"header,omitempty"`
+	Archive    string   `json:"archive,omitempty"`
+	Dir        string   `json:"dir,omitempty"`
+	Args       []string `json:"args,omitempty"`
+	Env        []string `json:"env,omitempty"`
+	Unset      []string `json:"unset,omitempty"`
+	Skipped    bool     `json:"skipped,omitempty"`
+	SkipReason string   `json:"skip_reason,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// NewPlanReport creates the report for the plans from Builder.Plan.
+func NewPlanReport(goVersion string, plans []*Plan) *PlanReport {
+	report := &PlanReport{
+		GoVersion: goVersion,
+		Jobs:      make([]*JobPlan, 0, len(plans)),
+	}
+	for _, plan := range plans {
+		r := &JobPlan{
+			Package:    plan.Package,
+			OS:         plan.Platform.OS,
+			Arch:       plan.Platform.Arch,
+			Variant:    plan.Platform.Variant,
+			Archive:    plan.Archive,
+			Skipped:    plan.Skipped,
+			SkipReason: plan.SkipReason,
+		}
+		if cmd := plan.Command; cmd != nil {
+			r.Output = cmd.Output
+			r.Header = headerPath(cmd.Output, plan.Opts.BuildMode)
+			r.Dir = cmd.Dir
+			r.Args = cmd.Args
+			r.Env = cmd.Env
+			r.Unset = cmd.Unset
+		}
+		if plan.Err != nil {
+			r.Error = plan.Err.Error()
+		}
+
+		report.Jobs = append(report.Jobs, r)
+	}
+
+	return report
+}
+
+// WritePlanReport writes the report as JSON to w.
+func WritePlanReport(w io.Writer, r *PlanReport) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,101 @@
+package gox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBuilderPlan(t *testing.T) {
+	td := testModule(t, map[string]string{
+		"main.go": "//go:build linux || windows\n\npackage main\n\nfunc main() {}\n",
+	})
+
+	linux := Platform{OS: "linux", Arch: "arm", Variant: "7"}
+	windows := Platform{OS: "windows", Arch: "amd64"}
+	darwin := Platform{OS: "darwin", Arch: "arm64"}
+	broken := Platform{OS: "plan9", Arch: "amd64"}
+	b := &Builder{
+		Packages:  []string{"_" + filepath.ToSlash(td)},
+		Platforms: []Platform{linux, windows, darwin, broken},
+		Opts: CompileOpts{
+			OutputTpl: filepath.Join(td, "dist", "{{.OS}}_{{.Arch}}"),
+			GoCmd:     "go",
+		},
+		Archive:      &ArchiveOpts{OutputTpl: filepath.Join(td, "dist", "{{.OS}}")},
+		SkipExcluded: true,
+		Override: func(opts *CompileOpts) error {
+			if opts.Platform == broken {
+				return errors.New("broken")
+			}
+
+			opts.Ldflags = "-s"
+			opts.Env = []string{"FOO=bar"}
+			return nil
+		},
+	}
+
+	plans, err := b.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(plans) != 4 {
+		t.Fatalf("bad: %#v", plans)
+	}
+
+	for _, plan := range plans {
+		switch plan.Platform {
+		case linux:
+			cmd := plan.Command
+			if cmd == nil {
+				t.Fatalf("err: %s", plan.Err)
+			}
+
+			output := filepath.Join(td, "dist", "linux_arm")
+			if cmd.Output != output || cmd.Dir != td {
+				t.Fatalf("bad: %#v", cmd)
+			}
+			if plan.Archive != filepath.Join(td, "dist", "linux.tar.gz") {
+				t.Fatalf("bad: %s", plan.Archive)
+			}
+
+			expected := []string{
+				"build",
+				"-gcflags", "",
+				"-ldflags", "-s",
+				"-asmflags", "",
+				"-tags", "",
+				"-o", output,
+				"",
+			}
+			if !reflect.DeepEqual(cmd.Args, expected) {
+				t.Fatalf("bad: %#v", cmd.Args)
+			}
+
+			expected = []string{"GOOS=linux", "GOARCH=arm", "GOARM=7", "CGO_ENABLED=0", "FOO=bar"}
+			if !reflect.DeepEqual(cmd.Env, expected) {
+				t.Fatalf("bad: %#v", cmd.Env)
+			}
+		case windows:
+			if plan.Command == nil || filepath.Ext(plan.Command.Output) != ".exe" {
+				t.Fatalf("bad: %#v", plan)
+			}
+		case darwin:
+			if !plan.Skipped || plan.Command != nil {
+				t.Fatalf("bad: %#v", plan)
+			}
+		case broken:
+			if plan.Err == nil || plan.Command != nil {
+				t.Fatalf("bad: %#v", plan)
+			}
+		}
+	}
+
+	// Planning doesn't build anything
+	if _, err := os.Stat(filepath.Join(td, "dist")); !os.IsNotExist(err) {
+		t.Fatalf("err: %s", err)
+	}
+}
@@ -0,0 +1,387 @@
+package gox
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	version "github.com/hashicorp/go-version"
+)
+
+// Platform is a combination of OS/arch that can be built against.
+type Platform struct {
+	OS   string
+	Arch string
+
+	// Default, if true, will be included as a default build target
+	// if no OS/arch is specified. We try to only set as a default popular
+	// targets or targets that are generally useful. For example, Android
+	// is not a default because it is quite rare that you're cross-compiling
+	// something to Android AND something like Linux.
+	Default bool
+
+	// Variant, if set, is the architecture variant to build for, such as
+	// "7" for GOARM or "v3" for GOAMD64. See ArchVariants. It comes after
+	// Default so that the existing fields keep their positions.
+	Variant string
+}
+
+func (p *Platform) String() string {
+	if p.Variant != "" {
+		return fmt.Sprintf("%s/%s/%s", p.OS, p.Arch, p.Variant)
+	}
+
+	return fmt.Sprintf("%s/%s", p.OS, p.Arch)
+}
+
+// archVariants are the valid variants of each architecture that has them.
+var archVariants = map[string][]string{
+	"386":      {"sse2", "softfloat"},
+	"amd64":    {"v1", "v2", "v3", "v4"},
+	"arm":      {"5", "6", "7"},
+	"mips":     {"hardfloat", "softfloat"},
+	"mipsle":   {"hardfloat", "softfloat"},
+	"mips64":   {"hardfloat", "softfloat"},
+	"mips64le": {"hardfloat", "softfloat"},
+	"ppc64":    {"power8", "power9", "power10"},
+	"ppc64le":  {"power8", "power9", "power10"},
+}
+
+// ArchVariants returns the valid variants of the architecture, or nil if
+// it has none.
+func ArchVariants(arch string) []string {
+	return archVariants[arch]
+}
+
+// variantEnv maps an architecture to the environment variable that picks
+// its variant.
+var variantEnv = map[string]string{
+	"386":      "GO386",
+	"amd64":    "GOAMD64",
+	"arm":      "GOARM",
+	"mips":     "GOMIPS",
+	"mipsle":   "GOMIPS",
+	"mips64":   "GOMIPS64",
+	"mips64le": "GOMIPS64",
+	"ppc64":    "GOPPC64",
+	"ppc64le":  "GOPPC64",
+}
+
+// validVariant returns true if variant is valid for the architecture.
+func validVariant(arch, variant string) bool {
+	for _, v := range archVariants[arch] {
+		if v == variant {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addDrop appends all of the "add" entries and drops the "drop" entries, ignoring
+// the "Default" parameter.
+func addDrop(base []Platform, add []Platform, drop []Platform) []Platform {
+	newPlatforms := make([]Platform, len(base)+len(add))
+	copy(newPlatforms, base)
+	copy(newPlatforms[len(base):], add)
+
+	// slow, but we only do this during initialization at most once per version
+	for _, platform := range drop {
+		found := -1
+		for i := range newPlatforms {
+			if newPlatforms[i].Arch == platform.Arch && newPlatforms[i].OS == platform.OS {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			panic(fmt.Sprintf("Expected to remove %+v but not found in list %+v", platform, newPlatforms))
+		}
+		if found == len(newPlatforms)-1 {
+			newPlatforms = newPlatforms[:found]
+		} else if found == 0 {
+			newPlatforms = newPlatforms[found:]
+		} else {
+			newPlatforms = append(newPlatforms[:found], newPlatforms[found+1:]...)
+		}
+	}
+	return newPlatforms
+}
+
+var (
+	Platforms_1_0 = []Platform{
+		{"darwin", "386", true, ""},
+		{"darwin", "amd64", true, ""},
+		{"linux", "386", true, ""},
+		{"linux", "amd64", true, ""},
+		{"linux", "arm", true, ""},
+		{"freebsd", "386", true, ""},
+		{"freebsd", "amd64", true, ""},
+		{"openbsd", "386", true, ""},
+		{"openbsd", "amd64", true, ""},
+		{"windows", "386", true, ""},
+		{"windows", "amd64", true, ""},
+	}
+
+	Platforms_1_1 = addDrop(Platforms_1_0, []Platform{
+		{"freebsd", "arm", true, ""},
+		{"netbsd", "386", true, ""},
+		{"netbsd", "amd64", true, ""},
+		{"netbsd", "arm", true, ""},
+		{"plan9", "386", false, ""},
+	}, nil)
+
+	Platforms_1_3 = addDrop(Platforms_1_1, []Platform{
+		{"dragonfly", "386", false, ""},
+		{"dragonfly", "amd64", false, ""},
+		{"nacl", "amd64", false, ""},
+		{"nacl", "amd64p32", false, ""},
+		{"nacl", "arm", false, ""},
+		{"solaris", "amd64", false, ""},
+	}, nil)
+
+	Platforms_1_4 = addDrop(Platforms_1_3, []Platform{
+		{"android", "arm", false, ""},
+		{"plan9", "amd64", false, ""},
+	}, nil)
+
+	Platforms_1_5 = addDrop(Platforms_1_4, []Platform{
+		{"darwin", "arm", false, ""},
+		{"darwin", "arm64", false, ""},
+		{"linux", "arm64", false, ""},
+		{"linux", "ppc64", false, ""},
+		{"linux", "ppc64le", false, ""},
+	}, nil)
+
+	Platforms_1_6 = addDrop(Platforms_1_5, []Platform{
+		{"android", "386", false, ""},
+		{"android", "amd64", false, ""},
+		{"linux", "mips64", false, ""},
+		{"linux", "mips64le", false, ""},
+		{"nacl", "386", false, ""},
+		{"openbsd", "arm", true, ""},
+	}, nil)
+
+	Platforms_1_7 = addDrop(Platforms_1_5, []Platform{
+		// While not fully supported s390x is generally useful
+		{"linux", "s390x", true, ""},
+		{"plan9", "arm", false, ""},
+		// Add the 1.6 Platforms, but reflect full support for mips64 and mips64le
+		{"android", "386", false, ""},
+		{"android", "amd64", false, ""},
+		{"linux", "mips64", true, ""},
+		{"linux", "mips64le", true, ""},
+		{"nacl", "386", false, ""},
+		{"openbsd", "arm", true, ""},
+	}, nil)
+
+	Platforms_1_8 = addDrop(Platforms_1_7, []Platform{
+		{"linux", "mips", true, ""},
+		{"linux", "mipsle", true, ""},
+	}, nil)
+
+	// no new platforms in 1.9
+	Platforms_1_9 = Platforms_1_8
+
+	// unannounced, but dropped support for android/amd64
+	Platforms_1_10 = addDrop(Platforms_1_9, nil, []Platform{{"android", "amd64", false, ""}})
+
+	Platforms_1_11 = addDrop(Platforms_1_10, []Platform{
+		{"js", "wasm", true, ""},
+	}, nil)
+
+	Platforms_1_12 = addDrop(Platforms_1_11, []Platform{
+		{"aix", "ppc64", false, ""},
+		{"windows", "arm", true, ""},
+	}, nil)
+
+	Platforms_1_13 = addDrop(Platforms_1_12, []Platform{
+		{"illumos", "amd64", false, ""},
+		{"netbsd", "arm64", true, ""},
+		{"openbsd", "arm64", true, ""},
+	}, nil)
+
+	Platforms_1_14 = addDrop(Platforms_1_13, []Platform{
+		{"freebsd", "arm64", true, ""},
+		{"linux", "riscv64", true, ""},
+	}, []Platform{
+		// drop nacl
+		{"nacl", "386", false, ""},
+		{"nacl", "amd64", false, ""},
+		{"nacl", "arm", false, ""},
+	})
+
+	Platforms_1_15 = addDrop(Platforms_1_14, []Platform{
+		{"android", "arm64", false, ""},
+	}, []Platform{
+		// drop i386 macos
+		{"darwin", "386", false, ""},
+	})
+
+	Platforms_1_16 = addDrop(Platforms_1_15, []Platform{
+		{"android", "amd64", false, ""},
+		{"darwin", "arm64", true, ""},
+		{"openbsd", "mips64", false, ""},
+	}, nil)
+
+	Platforms_1_17 = addDrop(Platforms_1_16, []Platform{
+		{"windows", "arm64", true, ""},
+	}, nil)
+
+	// no new platforms in 1.18
+	Platforms_1_18 = Platforms_1_17
+
+	PlatformsLatest = Platforms_1_18
+)
+
+// SupportedPlatforms returns the full list of supported platforms for
+// the version of Go that is
+func SupportedPlatforms(v string) []Platform {
+	// Use latest if we get an unexpected version string
+	if !strings.HasPrefix(v, "go") {
+		return PlatformsLatest
+	}
+	// go-version only cares about version numbers
+	v = v[2:]
+
+	current, err := version.NewVersion(v)
+	if err != nil {
+		log.Printf("Unable to parse current go version: %s\n%s", v, err.Error())
+
+		// Default to latest
+		return PlatformsLatest
+	}
+
+	var platforms = []struct {
+		constraint string
+		plat       []Platform
+	}{
+		{"<= 1.0", Platforms_1_0},
+		{">= 1.1, < 1.3", Platforms_1_1},
+		{">= 1.3, < 1.4", Platforms_1_3},
+		{">= 1.4, < 1.5", Platforms_1_4},
+		{">= 1.5, < 1.6", Platforms_1_5},
+		{">= 1.6, < 1.7", Platforms_1_6},
+		{">= 1.7, < 1.8", Platforms_1_7},
+		{">= 1.8, < 1.9", Platforms_1_8},
+		{">= 1.9, < 1.10", Platforms_1_9},
+		{">= 1.10, < 1.11", Platforms_1_10},
+		{">= 1.11, < 1.12", Platforms_1_11},
+		{">= 1.12, < 1.13", Platforms_1_12},
+		{">= 1.13, < 1.14", Platforms_1_13},
+		{">= 1.14, < 1.15", Platforms_1_14},
+		{">= 1.15, < 1.16", Platforms_1_15},
+		{">= 1.16, < 1.17", Platforms_1_16},
+		{">= 1.17, < 1.18", Platforms_1_17},
+		{">= 1.18, < 1.19", Platforms_1_18},
+	}
+
+	for _, p := range platforms {
+		constraints, err := version.NewConstraint(p.constraint)
+		if err != nil {
+			panic(err)
+		}
+		if constraints.Check(current) {
+			return p.plat
+		}
+	}
+
+	// Assume latest
+	return PlatformsLatest
+}
+
+// Sources that a list of supported platforms can come from, as shown
+// by -osarch-list.
+const (
+	PlatformSourceDist  = "go tool dist list"
+	PlatformSourceTable = "built-in table"
+)
+
+// distDefaultOS are the operating systems whose new ports are built by
+// default when the toolchain supports cgo for them, even if the port
+// isn't first-class. These are the desktop and server systems gox has always
+// targeted by default.
+var distDefaultOS = map[string]struct{}{
+	"darwin":  {},
+	"freebsd": {},
+	"linux":   {},
+	"netbsd":  {},
+	"openbsd": {},
+	"windows": {},
+}
+
+// distPlatform is a single entry in the output of `go tool dist list -json`.
+type distPlatform struct {
+	GOOS         string
+	GOARCH       string
+	CgoSupported bool
+	FirstClass   bool
+	Broken       bool
+}
+
+// isDefault determines whether a port reported by the toolchain should be
+// built when no OS/arch is specified. Ports that are in the built-in
+// table keep its Default, so that upgrading gox doesn't change what a
+// bare gox builds. For newer ports, first-class ports are included, as
+// are ports for one of the distDefaultOS systems that have cgo support,
+// which rules out the experimental ports that haven't been fleshed out
+// yet.
+func (p *distPlatform) isDefault() bool {
+	// Some ports appear more than once, such as darwin/arm64, in which
+	// case the later entry is the current one.
+	known, result := false, false
+	for _, platform := range PlatformsLatest {
+		if platform.OS == p.GOOS && platform.Arch == p.GOARCH {
+			known, result = true, platform.Default
+		}
+	}
+	if known {
+		return result
+	}
+
+	if p.FirstClass {
+		return true
+	}
+
+	_, ok := distDefaultOS[p.GOOS]
+	return ok && p.CgoSupported
+}
+
+// parseDistList parses the output of `go tool dist list -json` into
+// the list of platforms it describes. Broken ports are skipped.
+func parseDistList(data []byte) ([]Platform, error) {
+	var dist []distPlatform
+	if err := json.Unmarshal(data, &dist); err != nil {
+		return nil, fmt.Errorf("error parsing dist list: %s", err)
+	}
+
+	result := make([]Platform, 0, len(dist))
+	for _, p := range dist {
+		if p.Broken {
+			continue
+		}
+
+		result = append(result, Platform{
+			OS:      p.GOOS,
+			Arch:    p.GOARCH,
+			Default: p.isDefault(),
+		})
+	}
+
+	return result, nil
+}
+
+// GoSupportedPlatforms returns the list of supported platforms for the
+// toolchain behind GoCmd, along with a description of where the list came
+// from. The toolchain is queried directly when possible so that new ports
+// are picked up without a gox release. The built-in tables for the given
+// version are used as a fallback for toolchains that can't be queried.
+func GoSupportedPlatforms(GoCmd string, v string) ([]Platform, string) {
+	platforms, err := GoDistList(GoCmd)
+	if err == nil && len(platforms) > 0 {
+		return platforms, PlatformSourceDist
+	}
+
+	return SupportedPlatforms(v), PlatformSourceTable
+}
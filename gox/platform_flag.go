@@ -1,4 +1,4 @@
-package main
+package gox
 
 import (
 	"flag"
@@ -45,8 +45,9 @@ func (p *PlatformFlag) Platforms(supported []Platform) []Platform {
 	for _, v := range p.OSArch {
 		if v.OS[0] == '!' {
 			v = Platform{
-				OS:   v.OS[1:],
-				Arch: v.Arch,
+				OS:      v.OS[1:],
+				Arch:    v.Arch,
+				Variant: v.Variant,
 			}
 
 			ignoreOSArch[v.String()] = v
@@ -108,7 +109,7 @@ func (p *PlatformFlag) Platforms(supported []Platform) []Platform {
 		for _, pending := range prefilter {
 			found := false
 			for _, platform := range supported {
-				if pending.String() == platform.String() {
+				if pending.OS == platform.OS && pending.Arch == platform.Arch {
 					found = true
 					break
 				}
@@ -138,10 +139,14 @@ func (p *PlatformFlag) Platforms(supported []Platform) []Platform {
 	// Go through each default platform and filter out the bad ones
 	result := make([]Platform, 0, len(prefilter))
 	for _, platform := range prefilter {
+		// Skipping an os/arch without a variant skips all of its variants
 		if len(ignoreOSArch) > 0 {
 			if _, ok := ignoreOSArch[platform.String()]; ok {
 				continue
 			}
+			if _, ok := ignoreOSArch[platform.OS+"/"+platform.Arch]; ok {
+				continue
+			}
 		}
 
 		// We only want to check the components (OS and Arch) if we didn't
@@ -182,6 +187,42 @@ func (p *PlatformFlag) Platforms(supported []Platform) []Platform {
 	return result
 }
 
+// AddOS adds operating systems to build for or skip, using the same
+// syntax as the -os flag.
+func (p *PlatformFlag) AddOS(values ...string) error {
+	for _, v := range values {
+		if err := p.OSFlagValue().Set(v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddArch adds architectures to build for or skip, using the same syntax
+// as the -arch flag.
+func (p *PlatformFlag) AddArch(values ...string) error {
+	for _, v := range values {
+		if err := p.ArchFlagValue().Set(v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddOSArch adds os/arch pairs to build for or skip, using the same
+// syntax as the -osarch flag.
+func (p *PlatformFlag) AddOSArch(values ...string) error {
+	for _, v := range values {
+		if err := p.OSArchFlagValue().Set(v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // ArchFlagValue returns a flag.Value that can be used with the flag
 // package to collect the arches for the flag.
 func (p *PlatformFlag) ArchFlagValue() flag.Value {
@@ -200,9 +241,9 @@ func (p *PlatformFlag) OSArchFlagValue() flag.Value {
 	return (*appendPlatformValue)(&p.OSArch)
 }
 
-// appendPlatformValue is a flag.Value that appends a full platform (os/arch)
-// to a list where the values from space-separated lines. This is used to
-// satisfy the -osarch flag.
+// appendPlatformValue is a flag.Value that appends a full platform
+// (os/arch, or os/arch/variant) to a list where the values from
+// space-separated lines. This is used to satisfy the -osarch flag.
 type appendPlatformValue []Platform
 
 func (s *appendPlatformValue) String() string {
@@ -216,15 +257,28 @@ func (s *appendPlatformValue) Set(value string) error {
 
 	for _, v := range strings.Split(value, " ") {
 		parts := strings.Split(v, "/")
-		if len(parts) != 2 {
+		if len(parts) != 2 && len(parts) != 3 {
 			return fmt.Errorf(
-				"Invalid platform syntax: %s should be os/arch", v)
+				"Invalid platform syntax: %s should be os/arch or os/arch/variant", v)
 		}
 
 		platform := Platform{
 			OS:   strings.ToLower(parts[0]),
 			Arch: strings.ToLower(parts[1]),
 		}
+		if len(parts) == 3 {
+			platform.Variant = strings.ToLower(parts[2])
+			if len(ArchVariants(platform.Arch)) == 0 {
+				return fmt.Errorf(
+					"Invalid platform syntax: %s has no variants", platform.Arch)
+			}
+			if !validVariant(platform.Arch, platform.Variant) {
+				return fmt.Errorf(
+					"Invalid variant %q for %s, valid variants are: %s",
+					platform.Variant, platform.Arch,
+					strings.Join(ArchVariants(platform.Arch), ", "))
+			}
+		}
 
 		s.appendIfMissing(&platform)
 	}
@@ -1,4 +1,4 @@
-package main
+package gox
 
 import (
 	"flag"
@@ -20,13 +20,13 @@ func TestPlatformFlagPlatforms(t *testing.T) {
 			[]string{"baz"},
 			[]Platform{},
 			[]Platform{
-				{"foo", "baz", true},
-				{"bar", "baz", true},
-				{"boo", "bop", true},
+				{"foo", "baz", true, ""},
+				{"bar", "baz", true, ""},
+				{"boo", "bop", true, ""},
 			},
 			[]Platform{
-				{"foo", "baz", false},
-				{"bar", "baz", false},
+				{"foo", "baz", false, ""},
+				{"bar", "baz", false, ""},
 			},
 		},
 
@@ -36,12 +36,12 @@ func TestPlatformFlagPlatforms(t *testing.T) {
 			[]string{},
 			[]Platform{},
 			[]Platform{
-				{"foo", "bar", true},
-				{"foo", "baz", true},
-				{"bar", "bar", true},
+				{"foo", "bar", true, ""},
+				{"foo", "baz", true, ""},
+				{"bar", "bar", true, ""},
 			},
 			[]Platform{
-				{"bar", "bar", false},
+				{"bar", "bar", false, ""},
 			},
 		},
 
@@ -51,13 +51,13 @@ func TestPlatformFlagPlatforms(t *testing.T) {
 			[]string{},
 			[]Platform{},
 			[]Platform{
-				{"foo", "bar", true},
-				{"foo", "baz", true},
-				{"bar", "bar", true},
+				{"foo", "bar", true, ""},
+				{"foo", "baz", true, ""},
+				{"bar", "bar", true, ""},
 			},
 			[]Platform{
-				{"foo", "bar", false},
-				{"foo", "baz", false},
+				{"foo", "bar", false, ""},
+				{"foo", "baz", false, ""},
 			},
 		},
 
@@ -67,13 +67,13 @@ func TestPlatformFlagPlatforms(t *testing.T) {
 			[]string{"baz"},
 			[]Platform{},
 			[]Platform{
-				{"foo", "bar", true},
-				{"foo", "baz", true},
-				{"bar", "baz", true},
-				{"baz", "bar", true},
+				{"foo", "bar", true, ""},
+				{"foo", "baz", true, ""},
+				{"bar", "baz", true, ""},
+				{"baz", "bar", true, ""},
 			},
 			[]Platform{
-				{"bar", "baz", false},
+				{"bar", "baz", false, ""},
 			},
 		},
 
@@ -83,11 +83,11 @@ func TestPlatformFlagPlatforms(t *testing.T) {
 			[]string{"baz"},
 			[]Platform{},
 			[]Platform{
-				{"foo", "baz", true},
-				{"bar", "what", true},
+				{"foo", "baz", true, ""},
+				{"bar", "what", true, ""},
 			},
 			[]Platform{
-				{"foo", "baz", false},
+				{"foo", "baz", false, ""},
 			},
 		},
 
@@ -96,15 +96,49 @@ func TestPlatformFlagPlatforms(t *testing.T) {
 			[]string{},
 			[]string{},
 			[]Platform{
-				{"foo", "baz", true},
-				{"foo", "bar", true},
+				{"foo", "baz", true, ""},
+				{"foo", "bar", true, ""},
 			},
 			[]Platform{
-				{"foo", "baz", true},
-				{"bar", "what", true},
+				{"foo", "baz", true, ""},
+				{"bar", "what", true, ""},
 			},
 			[]Platform{
-				{"foo", "baz", false},
+				{"foo", "baz", false, ""},
+			},
+		},
+
+		// OSArch with a variant
+		{
+			[]string{},
+			[]string{},
+			[]Platform{
+				{"foo", "arm", true, "7"},
+			},
+			[]Platform{
+				{"foo", "arm", true, ""},
+				{"bar", "what", true, ""},
+			},
+			[]Platform{
+				{"foo", "arm", false, "7"},
+			},
+		},
+
+		// Negative OSArch without a variant skips every variant
+		{
+			[]string{},
+			[]string{},
+			[]Platform{
+				{"foo", "arm", true, "7"},
+				{"bar", "arm", true, "7"},
+				{"!foo", "arm", true, ""},
+			},
+			[]Platform{
+				{"foo", "arm", true, ""},
+				{"bar", "arm", true, ""},
+			},
+			[]Platform{
+				{"bar", "arm", false, "7"},
 			},
 		},
 
@@ -113,14 +147,14 @@ func TestPlatformFlagPlatforms(t *testing.T) {
 			[]string{},
 			[]string{},
 			[]Platform{
-				{"!foo", "baz", true},
+				{"!foo", "baz", true, ""},
 			},
 			[]Platform{
-				{"foo", "baz", true},
-				{"bar", "what", true},
+				{"foo", "baz", true, ""},
+				{"bar", "what", true, ""},
 			},
 			[]Platform{
-				{"bar", "what", false},
+				{"bar", "what", false, ""},
 			},
 		},
 
@@ -129,17 +163,17 @@ func TestPlatformFlagPlatforms(t *testing.T) {
 			[]string{"foo", "bar"},
 			[]string{"bar"},
 			[]Platform{
-				{"foo", "baz", true},
-				{"!bar", "bar", true},
+				{"foo", "baz", true, ""},
+				{"!bar", "bar", true, ""},
 			},
 			[]Platform{
-				{"foo", "bar", true},
-				{"foo", "baz", true},
-				{"bar", "bar", true},
+				{"foo", "bar", true, ""},
+				{"foo", "baz", true, ""},
+				{"bar", "bar", true, ""},
 			},
 			[]Platform{
-				{"foo", "baz", false},
-				{"foo", "bar", false},
+				{"foo", "baz", false, ""},
+				{"foo", "bar", false, ""},
 			},
 		},
 
@@ -149,13 +183,13 @@ func TestPlatformFlagPlatforms(t *testing.T) {
 			[]string{},
 			[]Platform{},
 			[]Platform{
-				{"foo", "bar", true},
-				{"foo", "baz", true},
-				{"bar", "bar", false},
+				{"foo", "bar", true, ""},
+				{"foo", "baz", true, ""},
+				{"bar", "bar", false, ""},
 			},
 			[]Platform{
-				{"foo", "bar", false},
-				{"foo", "baz", false},
+				{"foo", "bar", false, ""},
+				{"foo", "baz", false, ""},
 			},
 		},
 
@@ -165,12 +199,12 @@ func TestPlatformFlagPlatforms(t *testing.T) {
 			[]string{},
 			[]Platform{},
 			[]Platform{
-				{"foo", "bar", true},
-				{"foo", "baz", true},
-				{"bar", "bar", false},
+				{"foo", "bar", true, ""},
+				{"foo", "baz", true, ""},
+				{"bar", "bar", false, ""},
 			},
 			[]Platform{
-				{"bar", "bar", false},
+				{"bar", "bar", false, ""},
 			},
 		},
 
@@ -180,12 +214,12 @@ func TestPlatformFlagPlatforms(t *testing.T) {
 			[]string{"bar"},
 			[]Platform{},
 			[]Platform{
-				{"foo", "bar", true},
-				{"foo", "baz", true},
-				{"bar", "bar", false},
+				{"foo", "bar", true, ""},
+				{"foo", "baz", true, ""},
+				{"bar", "bar", false, ""},
 			},
 			[]Platform{
-				{"bar", "bar", false},
+				{"bar", "bar", false, ""},
 			},
 		},
 	}
@@ -224,7 +258,7 @@ func TestPlatformFlagOSArchFlagValue(t *testing.T) {
 		t.Fatalf("err: %s", err)
 	}
 
-	expected := []Platform{{"foo", "bar", false}}
+	expected := []Platform{{"foo", "bar", false, ""}}
 	if !reflect.DeepEqual(f.OSArch, expected) {
 		t.Fatalf("bad: %#v", f.OSArch)
 	}
@@ -266,13 +300,18 @@ func TestAppendPlatformValue(t *testing.T) {
 		t.Fatal("should err")
 	}
 
-	if err := value.Set("windows/arm windows/386"); err != nil {
+	if err := value.Set("linux/arm/8"); err == nil {
+		t.Fatal("should err")
+	}
+
+	if err := value.Set("windows/arm windows/386 linux/ARM/7"); err != nil {
 		t.Fatalf("err: %s", err)
 	}
 
 	expected := []Platform{
-		{"windows", "arm", false},
-		{"windows", "386", false},
+		{"windows", "arm", false, ""},
+		{"windows", "386", false, ""},
+		{"linux", "arm", false, "7"},
 	}
 	if !reflect.DeepEqual([]Platform(value), expected) {
 		t.Fatalf("bad: %#v", value)
@@ -321,3 +360,32 @@ func TestAppendStringValue(t *testing.T) {
 		t.Fatalf("bad: %#v", value)
 	}
 }
+
+func TestPlatformFlagAdd(t *testing.T) {
+	var f PlatformFlag
+	if err := f.AddOS("linux", "!windows DARWIN"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := f.AddArch("amd64"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := f.AddOSArch("linux/arm", "!darwin/386"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := PlatformFlag{
+		OS:   []string{"linux", "!windows", "darwin"},
+		Arch: []string{"amd64"},
+		OSArch: []Platform{
+			{"linux", "arm", false, ""},
+			{"!darwin", "386", false, ""},
+		},
+	}
+	if !reflect.DeepEqual(f, expected) {
+		t.Fatalf("bad: %#v", f)
+	}
+
+	if err := f.AddOSArch("linux"); err == nil {
+		t.Fatal("should err")
+	}
+}
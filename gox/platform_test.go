@@ -1,4 +1,4 @@
-package main
+package gox
 
 import (
 	"reflect"
@@ -148,3 +148,38 @@ func TestMIPS(t *testing.T) {
 		t.Fatal("Expected to find linux/mips64/true in go1.7 supported platforms")
 	}
 }
+
+func TestParseDistList(t *testing.T) {
+	data := []byte(`[
+	{"GOOS": "darwin", "GOARCH": "arm64", "CgoSupported": true, "FirstClass": true},
+	{"GOOS": "linux", "GOARCH": "arm64", "CgoSupported": true, "FirstClass": true},
+	{"GOOS": "js", "GOARCH": "wasm", "CgoSupported": false, "FirstClass": false},
+	{"GOOS": "linux", "GOARCH": "loong64", "CgoSupported": true, "FirstClass": false},
+	{"GOOS": "openbsd", "GOARCH": "ppc64", "CgoSupported": false, "FirstClass": false},
+	{"GOOS": "android", "GOARCH": "arm64", "CgoSupported": true, "FirstClass": false},
+	{"GOOS": "wasip1", "GOARCH": "wasm", "CgoSupported": false, "FirstClass": false},
+	{"GOOS": "linux", "GOARCH": "sparc64", "CgoSupported": true, "FirstClass": false, "Broken": true}
+]`)
+
+	ps, err := parseDistList(data)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []Platform{
+		{"darwin", "arm64", true, ""},
+		{"linux", "arm64", false, ""},
+		{"js", "wasm", true, ""},
+		{"linux", "loong64", true, ""},
+		{"openbsd", "ppc64", false, ""},
+		{"android", "arm64", false, ""},
+		{"wasip1", "wasm", false, ""},
+	}
+	if !reflect.DeepEqual(ps, expected) {
+		t.Fatalf("bad: %#v", ps)
+	}
+
+	if _, err := parseDistList([]byte("flag provided but not defined: -json")); err == nil {
+		t.Fatal("should err")
+	}
+}
@@ -0,0 +1,35 @@
+package gox
+
+import (
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// setProcessGroup makes the command the root of a new process group, so
+// that console interrupts are delivered to gox rather than straight to
+// the command.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP,
+	}
+}
+
+// terminateProcessGroup stops the command and every process it started.
+// Windows can't deliver a signal to another process group, so the whole
+// tree is killed.
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	return killProcessGroup(cmd)
+}
+
+// killProcessGroup kills the command along with the compiler and linker
+// processes it started, using taskkill to walk the process tree. If that
+// fails, at least the command itself is killed.
+func killProcessGroup(cmd *exec.Cmd) error {
+	kill := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid))
+	if err := kill.Run(); err != nil {
+		return cmd.Process.Kill()
+	}
+
+	return nil
+}
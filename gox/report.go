@@ -0,0 +1,115 @@
+package gox
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// Report is the machine-readable record of a set of builds, as written
+// by the -report flag.
+type Report struct {
+	GoVersion string       `json:"go_version"`
+	Jobs      []*JobReport `json:"jobs"`
+
+	// BrokenPackages are the packages that couldn't be loaded, and so
+	// weren't built. See PackageLoadError.
+	BrokenPackages []*BrokenPackage `json:"broken_packages,omitempty"`
+}
+
+// JobReport is the result of building a single package for a single
+// platform. The flags are the effective values after any per-platform
+// overrides were applied.
+type JobReport struct {
+	Package    string  `json:"package"`
+	OS         string  `json:"os"`
+	Arch       string  `json:"arch"`
+	Variant    string  `json:"variant,omitempty"`
+	Output     string  `json:"output,omitempty"`
+	Header     string  `json:"header,omitempty"`
+	Archive    string  `json:"archive,omitempty"`
+	Size       int64   `json:"size"`
+	Duration   float64 `json:"duration_seconds"`
+	ExitStatus int     `json:"exit_status"`
+	Cancelled  bool    `json:"cancelled,omitempty"`
+	Skipped    bool    `json:"skipped,omitempty"`
+	SkipReason string  `json:"skip_reason,omitempty"`
+	Cached     bool    `json:"cached,omitempty"`
+	TimedOut   bool    `json:"timed_out,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	Stderr     string  `json:"stderr,omitempty"`
+	Ldflags    string  `json:"ldflags"`
+	Gcflags    string  `json:"gcflags"`
+	Asmflags   string  `json:"asmflags"`
+	Tags       string  `json:"tags"`
+	BuildMode  string  `json:"buildmode,omitempty"`
+	GoVersion  string  `json:"go_version"`
+}
+
+// NewReport creates the report for the results of Builder.Build.
+func NewReport(goVersion string, results []*Result) *Report {
+	report := &Report{
+		GoVersion: goVersion,
+		Jobs:      make([]*JobReport, 0, len(results)),
+	}
+	for _, result := range results {
+		report.Jobs = append(report.Jobs, newJobReport(goVersion, result))
+	}
+
+	return report
+}
+
+// newJobReport creates the report for a single result. The exit status
+// is that of go build if it failed, or -1 if the job failed for any
+// other reason.
+func newJobReport(goVersion string, result *Result) *JobReport {
+	r := &JobReport{
+		Package:    result.Package,
+		OS:         result.Platform.OS,
+		Arch:       result.Platform.Arch,
+		Variant:    result.Platform.Variant,
+		Output:     result.Output,
+		Header:     result.Header,
+		Archive:    result.Archive,
+		Duration:   result.Duration.Seconds(),
+		Cancelled:  result.Cancelled,
+		Skipped:    result.Skipped,
+		SkipReason: result.SkipReason,
+		Cached:     result.Cached,
+		TimedOut:   result.TimedOut(),
+		Ldflags:    result.Ldflags,
+		Gcflags:    result.Opts.Gcflags,
+		Asmflags:   result.Opts.Asmflags,
+		Tags:       result.Opts.Tags,
+		BuildMode:  result.Opts.BuildMode,
+		GoVersion:  goVersion,
+	}
+
+	if err := result.Err; err != nil {
+		r.Error = err.Error()
+		r.ExitStatus = -1
+		if execErr, ok := err.(*ExecError); ok {
+			r.Error = execErr.Err.Error()
+			r.Stderr = execErr.Stderr
+			r.ExitStatus = execErr.ExitStatus()
+		}
+	}
+
+	if r.Output != "" {
+		if info, err := os.Stat(r.Output); err == nil {
+			r.Size = info.Size()
+		}
+	}
+
+	return r
+}
+
+// WriteReport writes the report as JSON to the given path.
+func WriteReport(path string, r *Report) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,90 @@
+package gox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewReport(t *testing.T) {
+	_, execErr := execGo(context.Background(), "go", nil, "", "build", "./does-not-exist")
+	results := []*Result{
+		{
+			Package:  "github.com/mitchellh/foo",
+			Platform: Platform{OS: "linux", Arch: "amd64"},
+			Ldflags:  "-s -w",
+			Err:      execErr,
+		},
+		{
+			Package:  "github.com/mitchellh/foo",
+			Platform: Platform{OS: "linux", Arch: "arm"},
+			Err:      errors.New("template: bad"),
+		},
+		{
+			Package:  "github.com/mitchellh/foo",
+			Platform: Platform{OS: "windows", Arch: "amd64"},
+			Err:      &TimeoutError{},
+		},
+	}
+
+	r := NewReport("go1.18", results)
+	if len(r.Jobs) != 3 {
+		t.Fatalf("bad: %#v", r.Jobs)
+	}
+
+	job := r.Jobs[0]
+	if job.ExitStatus <= 0 {
+		t.Fatalf("bad exit status: %d", job.ExitStatus)
+	}
+	if job.Stderr == "" {
+		t.Fatal("stderr should be captured")
+	}
+	if job.Ldflags != "-s -w" || job.GoVersion != "go1.18" {
+		t.Fatalf("bad: %#v", job)
+	}
+
+	job = r.Jobs[1]
+	if job.ExitStatus != -1 || job.Error != "template: bad" || job.Stderr != "" {
+		t.Fatalf("bad: %#v", job)
+	}
+
+	if !r.Jobs[2].TimedOut {
+		t.Fatalf("bad: %#v", r.Jobs[2])
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	td, err := ioutil.TempDir("", "gox")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	path := filepath.Join(td, "build.json")
+	r := &Report{
+		GoVersion: "go1.18",
+		Jobs: []*JobReport{
+			{Package: "a", OS: "linux", Arch: "386", ExitStatus: 1},
+		},
+	}
+	if err := WriteReport(path, r); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var result Report
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.GoVersion != "go1.18" || len(result.Jobs) != 1 || result.Jobs[0].ExitStatus != 1 {
+		t.Fatalf("bad: %s", data)
+	}
+}
@@ -0,0 +1,154 @@
+package gox
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reproducibleUnsetEnv are the environment variables that are removed
+// from the environment of reproducible builds. GOFLAGS can change the
+// build without it showing in the options, and the others can leak into
+// the output of C compilers.
+var reproducibleUnsetEnv = map[string]struct{}{
+	"GOFLAGS":  {},
+	"LANG":     {},
+	"LANGUAGE": {},
+	"TZ":       {},
+}
+
+// reproducibleUnset returns the names of the variables in env that are
+// removed for a reproducible build: those in reproducibleUnsetEnv and any
+// LC_* variables. The time zone and locale are then fixed by
+// GoBuildCommand.
+func reproducibleUnset(env []string) []string {
+	var result []string
+	for _, kv := range env {
+		key := kv
+		if i := strings.Index(kv, "="); i >= 0 {
+			key = kv[:i]
+		}
+		if _, ok := reproducibleUnsetEnv[key]; ok || strings.HasPrefix(key, "LC_") {
+			result = append(result, key)
+		}
+	}
+
+	return result
+}
+
+// SourceDate returns the time set by SOURCE_DATE_EPOCH, the number of
+// seconds since the Unix epoch, or the current time if it isn't set.
+// See https://reproducible-builds.org/specs/source-date-epoch/.
+func SourceDate() (time.Time, error) {
+	v := os.Getenv("SOURCE_DATE_EPOCH")
+	if v == "" {
+		return time.Now(), nil
+	}
+
+	seconds, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("SOURCE_DATE_EPOCH: %s", err)
+	}
+
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// NotReproducibleError is returned by VerifyReproducible when building
+// the same package twice gives different outputs.
+type NotReproducibleError struct {
+	Platform Platform
+	Output   string
+
+	// Offset is the first byte that differs.
+	Offset int64
+}
+
+func (e *NotReproducibleError) Error() string {
+	return fmt.Sprintf("%s is not reproducible: a second build differs at byte %d",
+		e.Output, e.Offset)
+}
+
+// VerifyReproducible builds the package in opts twice, each into its own
+// temporary directory with its own empty build cache, and compares the
+// results. A *NotReproducibleError is returned if they differ. The output
+// of the real build is only used to name the binaries and in the error,
+// so that neither build can pick up anything from the user's cache. Since
+// nothing is cached, this takes twice as long as building the package and
+// the standard library from scratch.
+func VerifyReproducible(ctx context.Context, opts *CompileOpts, output string) error {
+	td, err := newTempDir("", "gox-verify")
+	if err != nil {
+		return err
+	}
+	defer removeTempDir(td)
+
+	var outputs [2]string
+	for i := range outputs {
+		dir := filepath.Join(td, strconv.Itoa(i))
+
+		// The output already has its extension, so don't add another
+		verify := *opts
+		verify.OutputTpl = filepath.Join(dir, "out", filepath.Base(output))
+		verify.NoExt = true
+		verify.Env = append(append([]string{}, opts.Env...),
+			"GOCACHE="+filepath.Join(dir, "cache"))
+		outputs[i], err = GoCrossCompile(ctx, &verify)
+		if err != nil {
+			return err
+		}
+	}
+
+	offset, err := firstDifference(outputs[0], outputs[1])
+	if err != nil {
+		return err
+	}
+	if offset >= 0 {
+		return &NotReproducibleError{
+			Platform: opts.Platform,
+			Output:   output,
+			Offset:   offset,
+		}
+	}
+
+	return nil
+}
+
+// firstDifference returns the offset of the first byte that differs
+// between the two files, or -1 if they are the same.
+func firstDifference(a, b string) (int64, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return 0, err
+	}
+	defer fa.Close()
+
+	fb, err := os.Open(b)
+	if err != nil {
+		return 0, err
+	}
+	defer fb.Close()
+
+	ra, rb := bufio.NewReader(fa), bufio.NewReader(fb)
+	for offset := int64(0); ; offset++ {
+		ca, errA := ra.ReadByte()
+		cb, errB := rb.ReadByte()
+		if errA == io.EOF && errB == io.EOF {
+			return -1, nil
+		}
+		if errA != nil && errA != io.EOF {
+			return 0, errA
+		}
+		if errB != nil && errB != io.EOF {
+			return 0, errB
+		}
+		if errA != nil || errB != nil || ca != cb {
+			return offset, nil
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package gox
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReproducibleUnset(t *testing.T) {
+	env := []string{
+		"PATH=/bin",
+		"GOFLAGS=-ldflags=-X=main.date=now",
+		"TZ=America/New_York",
+		"LC_CTYPE=en_US.UTF-8",
+		"LANG=en_US.UTF-8",
+		"GOARM=6",
+	}
+	expected := []string{"GOFLAGS", "TZ", "LC_CTYPE", "LANG"}
+
+	actual := reproducibleUnset(env)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestSourceDate(t *testing.T) {
+	defer os.Unsetenv("SOURCE_DATE_EPOCH")
+
+	os.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	date, err := SourceDate()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !date.Equal(time.Unix(1700000000, 0)) || date.Location() != time.UTC {
+		t.Fatalf("bad: %s", date)
+	}
+
+	os.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+	if _, err := SourceDate(); err == nil {
+		t.Fatal("should err")
+	}
+
+	os.Unsetenv("SOURCE_DATE_EPOCH")
+	date, err = SourceDate()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if time.Since(date) > time.Minute {
+		t.Fatalf("bad: %s", date)
+	}
+}
+
+func TestFirstDifference(t *testing.T) {
+	td, err := ioutil.TempDir("", "gox")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	cases := []struct {
+		A, B     string
+		Expected int64
+	}{
+		{"", "", -1},
+		{"abc", "abc", -1},
+		{"abc", "abd", 2},
+		{"abc", "ab", 2},
+		{"", "a", 0},
+	}
+
+	for _, tc := range cases {
+		a, b := filepath.Join(td, "a"), filepath.Join(td, "b")
+		if err := ioutil.WriteFile(a, []byte(tc.A), 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := ioutil.WriteFile(b, []byte(tc.B), 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		actual, err := firstDifference(a, b)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if actual != tc.Expected {
+			t.Fatalf("%q %q: bad: %d", tc.A, tc.B, actual)
+		}
+	}
+}
+
+func TestGoCrossCompile_reproducible(t *testing.T) {
+	td := testModule(t, map[string]string{
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+
+	var outputs [][]byte
+	for _, dir := range []string{"a", "b"} {
+		opts := &CompileOpts{
+			PackagePath:  "_" + filepath.ToSlash(td),
+			Platform:     Platform{OS: "linux", Arch: "amd64"},
+			OutputTpl:    filepath.Join(td, dir, "foo"),
+			GoCmd:        "go",
+			Reproducible: true,
+		}
+		output, err := GoCrossCompile(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		data, err := ioutil.ReadFile(output)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if bytes.Contains(data, []byte(td)) {
+			t.Fatalf("%s contains the path to the source", output)
+		}
+
+		outputs = append(outputs, data)
+	}
+
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Fatal("outputs should be the same")
+	}
+}
+
+func TestVerifyReproducible(t *testing.T) {
+	td := testModule(t, map[string]string{
+		"main.go": "package main\n\nfunc main() {}\n",
+
+		// Records the build cache that each build is given, but builds
+		// with the usual one to keep the test fast. With GOX_TEST_NONCE
+		// set, the process ID is appended to the output so that every
+		// build differs.
+		"testgo": `#!/bin/sh
+if [ "$1" = build ]; then
+	echo "$GOCACHE" >> "$(dirname "$0")/caches"
+fi
+unset GOCACHE
+go "$@" || exit 1
+while [ $# -gt 0 ]; do
+	if [ "$1" = -o ] && [ -n "$GOX_TEST_NONCE" ]; then
+		echo $$ >> "$2"
+	fi
+	shift
+done
+`,
+	})
+	testGo := filepath.Join(td, "testgo")
+	if err := os.Chmod(testGo, 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	opts := &CompileOpts{
+		PackagePath:  "_" + filepath.ToSlash(td),
+		Platform:     Platform{OS: "linux", Arch: "amd64"},
+		OutputTpl:    filepath.Join(td, "dist", "foo"),
+		GoCmd:        testGo,
+		Reproducible: true,
+	}
+	output, err := GoCrossCompile(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	info, err := os.Stat(output)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := VerifyReproducible(context.Background(), opts, output); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The real build has no cache set, so only those of the two builds
+	// that verify it are left after trimming
+	data, err := ioutil.ReadFile(filepath.Join(td, "caches"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	caches := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(caches) != 2 || caches[0] == "" || caches[0] == caches[1] {
+		t.Fatalf("each build should have its own cache: %#v", caches)
+	}
+
+	opts.Env = []string{"GOX_TEST_NONCE=1"}
+	err = VerifyReproducible(context.Background(), opts, output)
+	notReproducible, ok := err.(*NotReproducibleError)
+	if !ok {
+		t.Fatalf("err: %s", err)
+	}
+	// The process IDs may start with the same digits
+	if notReproducible.Output != output || notReproducible.Offset < info.Size() {
+		t.Fatalf("bad: %#v", notReproducible)
+	}
+}
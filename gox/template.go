@@ -0,0 +1,179 @@
+package gox
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// OutputTemplateData is the data available to the -output and
+// -archive-output templates.
+type OutputTemplateData struct {
+	// Dir is the name of the directory of the package.
+	Dir string
+
+	OS   string
+	Arch string
+
+	// Package is the import path of the package, and Module is the path
+	// of the module it belongs to, if any.
+	Package string
+	Module  string
+
+	// Version is the version given by the user, if any.
+	Version string
+
+	// Commit, ShortCommit and Tag are detected from the git repository
+	// the package is in. Tag is only set if the commit is tagged. These
+	// are empty if the package isn't in a git repository.
+	Commit      string
+	ShortCommit string
+	Tag         string
+
+	// Ext is the extension of the output for the platform and build mode,
+	// such as ".exe" on Windows, ".wasm" for WebAssembly or ".so" for a
+	// c-shared library on Linux. It is empty for executables elsewhere.
+	Ext string
+
+	// Variant is the architecture variant of the platform, such as "7"
+	// for GOARM or "v3" for GOAMD64. If the platform doesn't have one,
+	// it's the value of the variable in the environment, if it is set.
+	Variant string
+
+	// Date is the time of the build in UTC, such as for use with
+	// {{.Date.Format "2006-01-02"}}.
+	Date time.Time
+}
+
+// templateFuncs are the functions available to output templates. The
+// arguments are ordered so that the string being changed comes last,
+// which lets them be used in pipelines: {{.Version | trimPrefix "v"}}.
+var templateFuncs = template.FuncMap{
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"env":        os.Getenv,
+}
+
+// DefaultOutputTemplate is the output template that is used if none is
+// given, which writes each binary to the current directory.
+const DefaultOutputTemplate = "{{.Dir}}_{{.OS}}_{{.Arch}}"
+
+// ParseOutputTemplate parses an output path template, with the helper
+// functions that are available to it.
+func ParseOutputTemplate(tpl string) (*template.Template, error) {
+	return template.New("output").Funcs(templateFuncs).Parse(tpl)
+}
+
+// PackageInfo are the details of a package that the output templates can
+// use and that have to be looked up, which are the same for every
+// platform. See LookupPackageInfo.
+type PackageInfo struct {
+	Module      string
+	Commit      string
+	ShortCommit string
+	Tag         string
+}
+
+// LookupPackageInfo finds the module and git details of the package at
+// the given import path, or "_" path. They are looked up on a
+// best-effort basis and are left empty if they can't be found.
+func LookupPackageInfo(GoCmd string, path string) *PackageInfo {
+	info := &PackageInfo{}
+	pkg, chdir := path, ""
+	if strings.HasPrefix(pkg, "_") {
+		pkg, chdir = ".", packageDir(pkg)
+	}
+
+	output, err := execGo(context.Background(), GoCmd, nil, chdir,
+		"list", "-f", "{{.Dir}}\n{{with .Module}}{{.Path}}{{end}}", pkg)
+	if err != nil {
+		return info
+	}
+	lines := strings.SplitN(strings.TrimSpace(output), "\n", 2)
+	dir := lines[0]
+	if len(lines) > 1 {
+		info.Module = lines[1]
+	}
+
+	info.Commit = git(dir, "rev-parse", "HEAD")
+	info.ShortCommit = git(dir, "rev-parse", "--short", "HEAD")
+	if info.Commit != "" {
+		info.Tag = git(dir, "describe", "--tags", "--exact-match", "HEAD")
+	}
+
+	return info
+}
+
+// NewOutputTemplateData returns the template data for building the given
+// options. The package details come from PackageInfo in the options, or
+// are looked up with LookupPackageInfo if it isn't set.
+func NewOutputTemplateData(opts *CompileOpts) *OutputTemplateData {
+	data := &OutputTemplateData{
+		Dir:     filepath.Base(opts.PackagePath),
+		OS:      opts.Platform.OS,
+		Arch:    opts.Platform.Arch,
+		Package: opts.PackagePath,
+		Version: opts.Version,
+		Ext:     binaryExt(opts.Platform, opts.BuildMode),
+		Variant: opts.Platform.Variant,
+		Date:    opts.Date.UTC(),
+	}
+	if data.Variant == "" {
+		data.Variant = os.Getenv(variantEnv[opts.Platform.Arch])
+	}
+	if opts.Date.IsZero() {
+		data.Date = time.Now().UTC()
+	}
+
+	// Packages outside of GOPATH and modules don't have an import path.
+	if strings.HasPrefix(opts.PackagePath, "_") {
+		data.Package = ""
+	}
+
+	info := opts.PackageInfo
+	if info == nil {
+		info = LookupPackageInfo(opts.GoCmd, opts.PackagePath)
+	}
+	data.Module = info.Module
+	data.Commit = info.Commit
+	data.ShortCommit = info.ShortCommit
+	data.Tag = info.Tag
+
+	return data
+}
+
+// renderTemplate renders an output path template with the given data.
+func renderTemplate(tpl string, data *OutputTemplateData) (string, error) {
+	t, err := ParseOutputTemplate(tpl)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// git runs git in the given directory and returns its trimmed output, or
+// an empty string if it failed.
+func git(dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(output))
+}
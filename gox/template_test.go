@@ -0,0 +1,96 @@
+package gox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	data := &OutputTemplateData{
+		Dir:     "foo",
+		OS:      "windows",
+		Arch:    "amd64",
+		Version: "v1.4.2",
+		Ext:     ".exe",
+		Date:    time.Date(2022, 3, 4, 0, 0, 0, 0, time.UTC),
+	}
+
+	cases := []struct {
+		Input  string
+		Output string
+	}{
+		{
+			`{{.Dir}}_{{.Version | trimPrefix "v"}}_{{.OS}}_{{.Arch}}{{.Ext}}`,
+			"foo_1.4.2_windows_amd64.exe",
+		},
+		{
+			`{{upper .OS}}-{{.Arch | replace "amd64" "x86_64"}}`,
+			"WINDOWS-x86_64",
+		},
+		{
+			`{{lower "FOO"}}_{{.Date.Format "20060102"}}`,
+			"foo_20220304",
+		},
+		{
+			`{{env "GOX_TEST_TEMPLATE"}}/{{.Dir}}`,
+			"bar/foo",
+		},
+	}
+
+	os.Setenv("GOX_TEST_TEMPLATE", "bar")
+	defer os.Unsetenv("GOX_TEST_TEMPLATE")
+	for _, tc := range cases {
+		output, err := renderTemplate(tc.Input, data)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if output != tc.Output {
+			t.Fatalf("input: %s\nbad: %s", tc.Input, output)
+		}
+	}
+}
+
+func TestNewOutputTemplateData(t *testing.T) {
+	td := testModule(t, map[string]string{
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+
+	os.Setenv("GOARM", "7")
+	defer os.Unsetenv("GOARM")
+	data := NewOutputTemplateData(&CompileOpts{
+		PackagePath: "_" + filepath.ToSlash(td),
+		Platform:    Platform{OS: "linux", Arch: "arm"},
+		GoCmd:       "go",
+		Version:     "1.0.0",
+	})
+
+	if data.Module != "example.com/foo" {
+		t.Fatalf("bad module: %#v", data)
+	}
+	if data.Dir != filepath.Base(td) || data.Version != "1.0.0" || data.Ext != "" {
+		t.Fatalf("bad: %#v", data)
+	}
+	if data.Variant != "7" {
+		t.Fatalf("bad variant: %#v", data)
+	}
+	if data.Date.IsZero() {
+		t.Fatal("date should be set")
+	}
+}
+
+func TestNewOutputTemplateData_packageInfo(t *testing.T) {
+	// The package doesn't exist, so the details can only come from the
+	// options.
+	data := NewOutputTemplateData(&CompileOpts{
+		PackagePath: "example.com/missing",
+		Platform:    Platform{OS: "linux", Arch: "amd64"},
+		GoCmd:       "go",
+		PackageInfo: &PackageInfo{Module: "example.com", Commit: "abc", Tag: "v1.0.0"},
+	})
+
+	if data.Module != "example.com" || data.Commit != "abc" || data.Tag != "v1.0.0" {
+		t.Fatalf("bad: %#v", data)
+	}
+}
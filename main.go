@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
 	"runtime"
 	"strings"
-	"sync"
+	"syscall"
+	"time"
 
 	version "github.com/hashicorp/go-version"
+	"github.com/mitchellh/gox/gox"
 )
 
 func main() {
@@ -23,12 +27,31 @@ func realMain() int {
 	var ldflags string
 	var outputTpl string
 	var parallel int
-	var platformFlag PlatformFlag
+	var platformFlag gox.PlatformFlag
 	var tags string
 	var verbose bool
 	var flagGcflags, flagAsmflags string
 	var flagCgo, flagRebuild, flagListOSArch, flagRaceFlag bool
 	var flagGoCmd string
+	var flagConfig string
+	var flagArchive bool
+	var flagArchiveOutput, flagArchiveFormat string
+	var flagArchiveFiles []string
+	var flagChecksum []string
+	var flagChecksumDir string
+	var flagChecksumJSON bool
+	var flagReport string
+	var flagFailFast bool
+	var flagTimeout time.Duration
+	var flagVersion string
+	var flagNoExt bool
+	var flagBuildMode string
+	var flagTrimpath bool
+	var flagReproducible, flagVerifyReproducible bool
+	var flagLinkVars []string
+	var flagNoCache bool
+	var flagDryRun bool
+	var flagDryRunFormat string
 	var modMode string
 	flags := flag.NewFlagSet("gox", flag.ExitOnError)
 	flags.Usage = func() { printUsage() }
@@ -37,7 +60,7 @@ func realMain() int {
 	flags.Var(platformFlag.OSFlagValue(), "os", "os to build for or skip")
 	flags.StringVar(&ldflags, "ldflags", "", "linker flags")
 	flags.StringVar(&tags, "tags", "", "go build tags")
-	flags.StringVar(&outputTpl, "output", "{{.Dir}}_{{.OS}}_{{.Arch}}", "output path")
+	flags.StringVar(&outputTpl, "output", gox.DefaultOutputTemplate, "output path")
 	flags.IntVar(&parallel, "parallel", -1, "parallelization factor")
 	flags.BoolVar(&buildToolchain, "build-toolchain", false, "build toolchain")
 	flags.BoolVar(&verbose, "verbose", false, "verbose")
@@ -49,11 +72,59 @@ func realMain() int {
 	flags.StringVar(&flagAsmflags, "asmflags", "", "")
 	flags.StringVar(&flagGoCmd, "gocmd", "go", "")
 	flags.StringVar(&modMode, "mod", "", "")
+	flags.StringVar(&flagConfig, "config", "", "")
+	flags.BoolVar(&flagArchive, "archive", false, "")
+	flags.StringVar(&flagArchiveOutput, "archive-output", gox.DefaultOutputTemplate, "")
+	flags.StringVar(&flagArchiveFormat, "archive-format", "", "")
+	flags.Var((*appendListValue)(&flagArchiveFiles), "archive-files", "")
+	flags.Var((*appendListValue)(&flagChecksum), "checksum", "")
+	flags.StringVar(&flagChecksumDir, "checksum-dir", ".", "")
+	flags.BoolVar(&flagChecksumJSON, "checksum-json", false, "")
+	flags.StringVar(&flagReport, "report", "", "")
+	flags.BoolVar(&flagFailFast, "fail-fast", false, "")
+	flags.DurationVar(&flagTimeout, "timeout", 0, "")
+	flags.StringVar(&flagVersion, "version", "", "")
+	flags.BoolVar(&flagNoExt, "no-ext", false, "")
+	flags.StringVar(&flagBuildMode, "buildmode", "", "")
+	flags.BoolVar(&flagTrimpath, "trimpath", false, "")
+	flags.BoolVar(&flagReproducible, "reproducible", false, "")
+	flags.BoolVar(&flagVerifyReproducible, "verify-reproducible", false, "")
+	flags.Var((*linkVarValue)(&flagLinkVars), "X", "")
+	flags.BoolVar(&flagNoCache, "no-cache", false, "")
+	flags.BoolVar(&flagDryRun, "dry-run", false, "")
+	flags.StringVar(&flagDryRunFormat, "dry-run-format", dryRunText, "")
 	if err := flags.Parse(os.Args[1:]); err != nil {
 		flags.Usage()
 		return 1
 	}
 
+	// The directives of a package only replace the output and ldflags if
+	// they weren't given on the command-line. This is checked before the
+	// config is applied, since that sets the flags too.
+	outputFlagSet := flagsSet(flags, "output")
+	ldflagsFlagSet := flagsSet(flags, "ldflags")
+
+	// Load the project config, if there is one. CLI flags always win over
+	// the config, so only flags that weren't set are taken from it.
+	config, err := loadConfig(flags, flagConfig, flagGoCmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %s\n", err)
+		return 1
+	}
+	if config != nil {
+		if err := config.ApplyFlags(flags); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading config: %s\n", err)
+			return 1
+		}
+
+		if !flagsSet(flags, "os", "arch", "osarch") {
+			if err := config.ApplyPlatformFlag(&platformFlag); err != nil {
+				fmt.Fprintf(os.Stderr, "Error loading config: %s\n", err)
+				return 1
+			}
+		}
+	}
+
 	// Determine what amount of parallelism we want Default to the current
 	// number of CPUs-1 is <= 0 is specified.
 	if parallel <= 0 {
@@ -83,32 +154,71 @@ func realMain() int {
 		return 1
 	}
 
-	versionStr, err := GoVersion()
+	versionStr, err := gox.GoVersion()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error reading Go version: %s", err)
 		return 1
 	}
 
+	supported, source := gox.GoSupportedPlatforms(flagGoCmd, versionStr)
 	if flagListOSArch {
-		return mainListOSArch(versionStr)
+		return mainListOSArch(versionStr, supported, source)
 	}
 
 	// Determine the packages that we want to compile. Default to the
 	// current directory if none are specified.
 	packages := flags.Args()
+	if len(packages) == 0 && config != nil {
+		packages = config.Packages
+	}
 	if len(packages) == 0 {
 		packages = []string{"."}
 	}
 
-	// Get the packages that are in the given paths
-	mainDirs, err := GoMainDirs(packages, flagGoCmd)
-	if err != nil {
+	// Get the packages that are in the given paths. Packages that can't be
+	// loaded are reported, and the rest are still built.
+	mains, err := gox.GoMainPackages(packages, flagGoCmd)
+	loadErr, _ := err.(*gox.PackageLoadError)
+	if err != nil && loadErr == nil {
 		fmt.Fprintf(os.Stderr, "Error reading packages: %s", err)
 		return 1
 	}
 
+	// A typo in //gox:platforms would silently leave the package out of
+	// the build, so packages with platforms Go doesn't support are broken.
+	loadable := mains[:0]
+	for _, pkg := range mains {
+		unknown := pkg.Directives.UnknownPlatforms(supported)
+		if len(unknown) == 0 {
+			loadable = append(loadable, pkg)
+			continue
+		}
+
+		if loadErr == nil {
+			loadErr = &gox.PackageLoadError{}
+		}
+		loadErr.Packages = append(loadErr.Packages, &gox.BrokenPackage{
+			ImportPath: pkg.ImportPath,
+			Err: fmt.Sprintf("//gox:platforms %s: no such platform, see -osarch-list",
+				strings.Join(unknown, " ")),
+		})
+	}
+	mains = loadable
+
+	if loadErr != nil {
+		fmt.Fprintf(os.Stderr, "%d packages could not be loaded and won't be built:\n", len(loadErr.Packages))
+		for _, p := range loadErr.Packages {
+			fmt.Fprintf(os.Stderr, "--> %s: %s\n", p.ImportPath, p.Err)
+		}
+		fmt.Fprintln(os.Stderr)
+
+		if len(mains) == 0 {
+			return 1
+		}
+	}
+
 	// Determine the platforms we're building for
-	platforms := platformFlag.Platforms(SupportedPlatforms(versionStr))
+	platforms := platformFlag.Platforms(supported)
 	if len(platforms) == 0 {
 		fmt.Println("No valid platforms to build for. If you specified a value")
 		fmt.Println("for the 'os', 'arch', or 'osarch' flags, make sure you're")
@@ -116,85 +226,351 @@ func realMain() int {
 		return 1
 	}
 
+	// Packages may choose their own platforms with //gox:platforms. If
+	// platforms were given explicitly then they only narrow those down,
+	// otherwise they choose from every platform that Go supports.
+	explicit := len(platformFlag.OS) > 0 || len(platformFlag.Arch) > 0 ||
+		len(platformFlag.OSArch) > 0
+	allPlatforms := make([]gox.Platform, len(supported))
+	for i, p := range supported {
+		p.Default = false
+		allPlatforms[i] = p
+	}
+
+	mainDirs := make([]string, len(mains))
+	mainsByPath := make(map[string]*gox.MainPackage, len(mains))
+	packagePlatforms := make(map[string][]gox.Platform)
+	for i, pkg := range mains {
+		mainDirs[i] = pkg.ImportPath
+		mainsByPath[pkg.ImportPath] = pkg
+		if len(pkg.Directives.Platforms) == 0 {
+			continue
+		}
+
+		candidates := platforms
+		if !explicit && pkg.Directives.HasIncludes() {
+			candidates = allPlatforms
+		}
+		packagePlatforms[pkg.ImportPath] = pkg.Directives.FilterPlatforms(candidates)
+		if len(packagePlatforms[pkg.ImportPath]) == 0 {
+			fmt.Fprintf(os.Stderr, "%s won't be built: its //gox:platforms match none of the platforms\n",
+				pkg.ImportPath)
+		}
+	}
+
 	// Assume -mod is supported when no version prefix is found
-	if modMode != "" && strings.HasPrefix(versionStr, "go") {
-		// go-version only cares about version numbers
-		current, err := version.NewVersion(versionStr[2:])
+	if modMode != "" {
+		ok, err := goVersionAtLeast(versionStr, "1.11")
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Unable to parse current go version: %s\n%s", versionStr, err.Error())
 			return 1
 		}
 
-		constraint, err := version.NewConstraint(">= 1.11")
+		if !ok {
+			fmt.Printf("Go compiler version %s does not support the -mod flag\n", versionStr)
+			modMode = ""
+		}
+	}
+
+	// Verifying only makes sense if the builds are meant to be reproducible
+	if flagVerifyReproducible {
+		flagReproducible = true
+	}
+	if flagReproducible {
+		ok, err := goVersionAtLeast(versionStr, "1.18")
 		if err != nil {
-			panic(err)
+			fmt.Fprintf(os.Stderr, "Unable to parse current go version: %s\n%s", versionStr, err.Error())
+			return 1
 		}
 
-		if !constraint.Check(current) {
-			fmt.Printf("Go compiler version %s does not support the -mod flag\n", versionStr)
-			modMode = ""
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Go compiler version %s does not support -reproducible, which needs Go 1.18\n", versionStr)
+			return 1
+		}
+	}
+
+	if flagArchiveFormat != "" && flagArchiveFormat != gox.ArchiveZip && flagArchiveFormat != gox.ArchiveTarGz {
+		fmt.Fprintf(os.Stderr, "Unknown archive format: %s\n", flagArchiveFormat)
+		return 1
+	}
+
+	if err := gox.ValidateBuildMode(flagBuildMode); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+
+	if flagDryRunFormat != dryRunText && flagDryRunFormat != dryRunJSON {
+		fmt.Fprintf(os.Stderr, "Unknown dry run format: %s\n", flagDryRunFormat)
+		return 1
+	}
+
+	for i, alg := range flagChecksum {
+		flagChecksum[i] = strings.ToLower(alg)
+	}
+	if err := gox.ValidateChecksumAlgorithms(flagChecksum); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+
+	builder := &gox.Builder{
+		Packages:         mainDirs,
+		Platforms:        platforms,
+		PackagePlatforms: packagePlatforms,
+		Parallel:         parallel,
+		FailFast:         flagFailFast,
+		Opts: gox.CompileOpts{
+			OutputTpl: outputTpl,
+			Ldflags:   ldflags,
+			Gcflags:   flagGcflags,
+			Asmflags:  flagAsmflags,
+			Tags:      tags,
+			ModMode:   modMode,
+			Cgo:       flagCgo,
+			Rebuild:   flagRebuild,
+			GoCmd:     flagGoCmd,
+			Race:      flagRaceFlag,
+			Verbose:   verbose,
+			Timeout:   flagTimeout,
+			Version:   flagVersion,
+			NoExt:     flagNoExt,
+			BuildMode: flagBuildMode,
+			Trimpath:  flagTrimpath,
+
+			LinkVars:     flagLinkVars,
+			Reproducible: flagReproducible,
+		},
+		Checksums:          flagChecksum,
+		VerifyReproducible: flagVerifyReproducible,
+		SkipUnsupported:    true,
+		SkipExcluded:       true,
+		Stdout:             os.Stdout,
+
+		// Determine if we have specific CFLAGS or LDFLAGS for this
+		// GOOS/GOARCH combo and override the defaults if so. The
+		// environment takes precedence over the config, which takes
+		// precedence over the directives in the package, which only
+		// apply if the flags weren't given on the command-line.
+		Override: func(opts *gox.CompileOpts) error {
+			if pkg, ok := mainsByPath[opts.PackagePath]; ok {
+				if pkg.Directives.Output != "" && !outputFlagSet {
+					// A relative output is relative to the package, so
+					// that it doesn't depend on where gox is run from.
+					opts.OutputTpl = relTemplate(pkg.Dir, pkg.Directives.Output)
+				}
+				if pkg.Directives.Ldflags != "" && !ldflagsFlagSet {
+					opts.Ldflags = pkg.Directives.Ldflags
+				}
+			}
+			if config != nil {
+				config.Override(opts)
+			}
+			return envOverrides(opts)
+		},
+	}
+	if flagArchive {
+		builder.Archive = &gox.ArchiveOpts{
+			OutputTpl: flagArchiveOutput,
+			Format:    flagArchiveFormat,
+			Files:     flagArchiveFiles,
+		}
+	}
+
+	// With -dry-run, show what would be built instead of building it.
+	if flagDryRun {
+		return mainDryRun(builder, versionStr, flagDryRunFormat, loadErr)
+	}
+
+	// Outputs that are already up to date aren't built again. A cache that
+	// can't be read shouldn't stop the build, so it is only a warning.
+	if !flagNoCache {
+		path, err := gox.DefaultBuildCachePath()
+		if err == nil {
+			builder.Cache, err = gox.OpenBuildCache(path)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Not using the build cache: %s\n", err)
 		}
 	}
 
-	// Build in parallel!
+	// Build in parallel! With -fail-fast, the first failure cancels the
+	// rest, killing any builds in progress and skipping the others.
 	fmt.Printf("Number of parallel builds: %d\n\n", parallel)
-	var errorLock sync.Mutex
-	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleInterrupts(cancel)
+	results, buildErr := builder.Build(ctx)
+
+	// Anything that would fail, such as a missing C cross-compiler or
+	// outputs that would overwrite each other, is reported before
+	// anything is built.
+	if _, ok := buildErr.(*gox.CheckError); ok {
+		printCheckError(buildErr)
+		return 1
+	}
+
 	errors := make([]string, 0)
-	semaphore := make(chan int, parallel)
-	for _, platform := range platforms {
-		for _, path := range mainDirs {
-			// Start the goroutine that will do the actual build
-			wg.Add(1)
-			go func(path string, platform Platform) {
-				defer wg.Done()
-				semaphore <- 1
-				fmt.Printf("--> %15s: %s\n", platform.String(), path)
-
-				opts := &CompileOpts{
-					PackagePath: path,
-					Platform:    platform,
-					OutputTpl:   outputTpl,
-					Ldflags:     ldflags,
-					Gcflags:     flagGcflags,
-					Asmflags:    flagAsmflags,
-					Tags:        tags,
-					ModMode:     modMode,
-					Cgo:         flagCgo,
-					Rebuild:     flagRebuild,
-					GoCmd:       flagGoCmd,
-					Race:        flagRaceFlag,
-				}
+	if buildErr, ok := buildErr.(*gox.BuildError); ok {
+		for _, result := range buildErr.Failed {
+			errors = append(errors,
+				fmt.Sprintf("%s error: %s", result.Platform.String(), result.Err))
+		}
+	}
 
-				// Determine if we have specific CFLAGS or LDFLAGS for this
-				// GOOS/GOARCH combo and override the defaults if so.
-				envOverride(&opts.Ldflags, platform, "LDFLAGS")
-				envOverride(&opts.Gcflags, platform, "GCFLAGS")
-				envOverride(&opts.Asmflags, platform, "ASMFLAGS")
-
-				if err := GoCrossCompile(opts); err != nil {
-					errorLock.Lock()
-					defer errorLock.Unlock()
-					errors = append(errors,
-						fmt.Sprintf("%s error: %s", platform.String(), err))
-				}
-				<-semaphore
-			}(path, platform)
+	if builder.Cache != nil {
+		cached := 0
+		for _, result := range results {
+			if result.Cached {
+				cached++
+			}
+		}
+		if cached > 0 {
+			fmt.Printf("\n%d builds were already up to date. Use -rebuild to build them anyway\n", cached)
+		}
+
+		if err := builder.Cache.Save(); err != nil {
+			errors = append(errors, fmt.Sprintf("error writing build cache: %s", err))
+		}
+	}
+
+	if len(flagChecksum) > 0 {
+		checksums := make([]*gox.Checksum, 0)
+		for _, result := range results {
+			checksums = append(checksums, result.Checksums...)
+		}
+
+		if err := gox.WriteChecksums(flagChecksumDir, flagChecksum, checksums, flagChecksumJSON); err != nil {
+			errors = append(errors, fmt.Sprintf("error writing checksums: %s", err))
+		}
+	}
+
+	if flagReport != "" {
+		report := gox.NewReport(versionStr, results)
+		if loadErr != nil {
+			report.BrokenPackages = loadErr.Packages
+		}
+		if err := gox.WriteReport(flagReport, report); err != nil {
+			errors = append(errors, fmt.Sprintf("error writing report: %s", err))
 		}
 	}
-	wg.Wait()
 
 	if len(errors) > 0 {
 		fmt.Fprintf(os.Stderr, "\n%d errors occurred:\n", len(errors))
 		for _, err := range errors {
 			fmt.Fprintf(os.Stderr, "--> %s\n", err)
 		}
+	}
+	if buildErr, ok := buildErr.(*gox.BuildError); ok {
+		if len(buildErr.TimedOut) > 0 {
+			fmt.Fprintf(os.Stderr, "\n%d builds timed out:\n", len(buildErr.TimedOut))
+			for _, result := range buildErr.TimedOut {
+				fmt.Fprintf(os.Stderr, "--> %s: %s (%s)\n",
+					result.Platform.String(), result.Package, result.Err)
+			}
+		}
+		if len(buildErr.Cancelled) > 0 {
+			fmt.Fprintf(os.Stderr, "\n%d builds were cancelled:\n", len(buildErr.Cancelled))
+			for _, result := range buildErr.Cancelled {
+				fmt.Fprintf(os.Stderr, "--> %s: %s\n",
+					result.Platform.String(), result.Package)
+			}
+		}
+	}
+	skipped := make([]*gox.Result, 0)
+	for _, result := range results {
+		if result.Skipped {
+			skipped = append(skipped, result)
+		}
+	}
+	if len(skipped) > 0 {
+		fmt.Fprintf(os.Stderr, "\n%d builds were skipped as not applicable:\n", len(skipped))
+		for _, result := range skipped {
+			fmt.Fprintf(os.Stderr, "--> %s: %s (%s)\n",
+				result.Platform.String(), result.Package, result.SkipReason)
+		}
+	}
+
+	// Having nothing to build isn't an error, but it is surprising, so
+	// say why. Otherwise it is an error if every build was skipped.
+	if len(results) == 0 {
+		if len(mains) == 0 {
+			fmt.Fprintf(os.Stderr, "Nothing was built: no main packages were found in %s\n",
+				strings.Join(packages, " "))
+		} else {
+			fmt.Fprintf(os.Stderr, "Nothing was built: no package is built for any of the platforms\n")
+		}
+	}
+	allSkipped := len(results) > 0 && len(skipped) == len(results)
+	if len(errors) > 0 || buildErr != nil || allSkipped || loadErr != nil {
 		return 1
 	}
 
 	return 0
 }
 
+// handleInterrupts cancels the builds on the first interrupt, which lets
+// them stop cleanly and remove incomplete outputs. A second interrupt
+// kills every build immediately and exits.
+func handleInterrupts(cancel context.CancelFunc) {
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	<-sigCh
+	fmt.Fprintln(os.Stderr, "\nInterrupted, stopping builds. Interrupt again to force quit.")
+	cancel()
+
+	<-sigCh
+	gox.KillAll()
+	os.Exit(1)
+}
+
+// loadConfig loads the config given with -config. If the flag wasn't
+// given then a config in the module root is used, if one exists. Setting
+// -config to an empty string disables the config entirely.
+func loadConfig(flags *flag.FlagSet, path string, GoCmd string) (*Config, error) {
+	if !flagsSet(flags, "config") {
+		var err error
+		path, err = FindConfig(GoCmd)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if path == "" {
+		return nil, nil
+	}
+
+	return LoadConfig(path)
+}
+
+// flagsSet returns true if any of the named flags were set on the
+// command-line.
+func flagsSet(flags *flag.FlagSet, names ...string) bool {
+	result := false
+	flags.Visit(func(f *flag.Flag) {
+		for _, name := range names {
+			if f.Name == name {
+				result = true
+			}
+		}
+	})
+
+	return result
+}
+
+// printCheckError prints the problems that Builder.Check found.
+func printCheckError(err error) {
+	checkErr, ok := err.(*gox.CheckError)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "%d problems found before building:\n", len(checkErr.Errors))
+	for _, err := range checkErr.Errors {
+		fmt.Fprintf(os.Stderr, "--> %s\n", err)
+	}
+}
+
 func printUsage() {
 	fmt.Fprintf(os.Stderr, helpText)
 }
@@ -204,18 +580,41 @@ const helpText = `Usage: gox [options] [packages]
   Gox cross-compiles Go applications in parallel.
 
   If no specific operating systems or architectures are specified, Gox
-  will build for all pairs supported by your version of Go.
+  will build for all default pairs supported by your version of Go.
+  Platforms that a package's build constraints exclude, such as Windows
+  for a package with "//go:build linux || darwin", are skipped rather
+  than failed, and listed at the end. Packages that can't be loaded, such
+  as those with a missing import, are listed before building and the rest
+  are built, but gox exits with an error.
 
 Options:
 
   -arch=""            Space-separated list of architectures to build for
+  -archive            Package each binary into an archive. See below for more info
+  -archive-files=""   Space-separated list of extra files or globs to archive
+  -archive-format=""  Archive format, "zip" or "tar.gz". Defaults per OS
+  -archive-output="{{.Dir}}_{{.OS}}_{{.Arch}}"
+                      Archive path template, without the extension
   -build-toolchain    Build cross-compilation toolchain
+  -buildmode=""       Build mode to pass to go build, e.g. "c-shared"
   -cgo                Sets CGO_ENABLED=1, requires proper C toolchain (advanced)
+  -checksum=""        Space-separated list of checksums: sha256, sha512, blake2b
+  -checksum-dir="."   Directory to write the checksum manifests to
+  -checksum-json      Also write the checksums as JSON to checksums.json
+  -config=""          Path to a gox.hcl or gox.json config file
+  -dry-run            Print what would be built, without building. See below
+  -dry-run-format="text"  Format of -dry-run, "text" or "json"
+  -fail-fast          Stop all builds as soon as one fails
   -gcflags=""         Additional '-gcflags' value to pass to go build
   -ldflags=""         Additional '-ldflags' value to pass to go build
   -asmflags=""        Additional '-asmflags' value to pass to go build
   -tags=""            Additional '-tags' value to pass to go build
+  -trimpath           Remove file system paths from the binaries
+  -X key=value        Set a string variable with the linker. See below for more info
+  -timeout=0          Kill any build that runs longer than this, e.g. "5m"
   -mod=""             Additional '-mod' value to pass to go build
+  -no-cache           Build everything without using or updating the build cache
+  -no-ext             Don't add the extension to the output path automatically
   -os=""              Space-separated list of operating systems to build for
   -osarch=""          Space-separated list of os/arch pairs to build for
   -osarch-list        List supported os/arch pairs for your Go version
@@ -223,15 +622,113 @@ Options:
   -parallel=-1        Amount of parallelism, defaults to number of CPUs
   -race               Build with the go race detector enabled, requires CGO
   -gocmd="go"         Build command, defaults to Go
-  -rebuild            Force rebuilding of package that were up to date
-  -verbose            Verbose mode
+  -rebuild            Force rebuilding of package that were up to date,
+                      even if they are in the build cache
+  -report=""          Write a JSON report of every build to this path
+  -reproducible       Build reproducibly. See below for more info
+  -verbose            Verbose mode, streams the output of each build
+  -verify-reproducible  Build everything twice in isolation and fail if the outputs differ
+  -version=""         Version to make available to the output templates
 
 Output path template:
 
   The output path for the compiled binaries is specified with the
   "-output" flag. The value is a string that is a Go text template.
-  The default value is "{{.Dir}}_{{.OS}}_{{.Arch}}". The variables and
-  their values should be self-explanatory.
+  The default value is "{{.Dir}}_{{.OS}}_{{.Arch}}". The variables are:
+
+    {{.Dir}}          Name of the package directory
+    {{.OS}}           Target operating system
+    {{.Arch}}         Target architecture
+    {{.Package}}      Import path of the package
+    {{.Module}}       Path of the module the package is in
+    {{.Version}}      Value of the "-version" flag
+    {{.Commit}}       Git commit of the package, also {{.ShortCommit}}
+    {{.Tag}}          Git tag of the commit, if it is tagged
+    {{.Ext}}          ".exe" on Windows, empty otherwise
+    {{.Variant}}      Architecture variant, e.g. "7" for linux/arm/7
+    {{.Date}}         Time of the build, e.g. {{.Date.Format "20060102"}}
+
+  The functions upper, lower, replace, trimPrefix, trimSuffix and env are
+  also available, for example {{.Version | trimPrefix "v"}} or
+  {{env "USER"}}.
+
+  The extension in {{.Ext}} depends on the platform and build mode: ".exe"
+  on Windows, ".wasm" for WebAssembly, ".dll", ".so" or ".dylib" for
+  c-shared libraries and ".a" for c-archive. It is added to the output
+  path automatically unless the path already ends with it, or "-no-ext"
+  is given.
+
+  Every output path, and every archive path with "-archive", is rendered
+  before anything is built. If the template can't be rendered, or more
+  than one build would write to the same path, such as when the template
+  doesn't include {{.OS}} and {{.Arch}}, nothing is built.
+
+Dry Run:
+
+  With "-dry-run", gox prints every package and platform it would build,
+  with the output path, the go build command and the environment it
+  would run it with, and then exits without building anything. Only the
+  variables that gox sets are shown, such as GOOS, GOARCH, CGO_ENABLED,
+  the C toolchain and any set with GOX_<OS>_<ARCH>_ENV_<NAME>, after the
+  config and the platform overrides have been applied. Builds that would
+  be skipped, and problems that would stop the build, are reported too.
+
+  With "-dry-run-format=json", the same is written to stdout as JSON,
+  with a job for every package and platform:
+
+    {"package": "...", "os": "linux", "arch": "amd64", "output": "...",
+     "dir": "...", "args": ["build", ...], "env": ["GOOS=linux", ...]}
+
+Build Cache:
+
+  Gox remembers what each output was built from, and doesn't run go build
+  again when nothing has changed. This covers the source of the package
+  and everything it imports, the flags and environment of the build, and
+  the version of Go. An output that was changed or removed since it was
+  built is built again. The index is kept in the user cache directory,
+  such as ~/.cache/gox on Linux. Use "-rebuild" to build everything
+  anyway, or "-no-cache" to not use the cache at all.
+
+Setting Variables:
+
+  "-X importpath.name=value" sets a string variable in the binaries with
+  the linker, like "-ldflags=-X ..." but without the quoting. It may be
+  given more than once. These are added to the ldflags of every build,
+  even when they are overridden for a platform. The values are output
+  templates, so everything from the section above is available:
+
+    gox -version=1.2.0 \
+      -X "main.version={{.Version}}" \
+      -X "main.commit={{.ShortCommit}}" \
+      -X "main.platform={{.OS}}/{{.Arch}}"
+
+Build Modes:
+
+  The "-buildmode" flag is passed to go build, so that libraries can be
+  built with "c-shared", "c-archive", "plugin" or "shared", or position
+  independent executables with "pie". Platforms that don't support the
+  build mode are skipped. The library modes need cgo, which is only
+  enabled for the host platform unless "-cgo" is given along with a C
+  cross-compiler. The C header written by "c-shared" and "c-archive" is
+  placed next to the library, and is added to the archive and report.
+
+Archives:
+
+  With "-archive", each binary is packaged into an archive after it is
+  built, along with any files given by "-archive-files". Windows binaries
+  are packaged as zip files and all others as tar.gz files, unless
+  "-archive-format" is set. The archive path is rendered from the
+  "-archive-output" template, which has the same variables as "-output"
+  and defaults to "{{.Dir}}_{{.OS}}_{{.Arch}}". The extension is added
+  automatically. Timestamps and file modes within the archive are fixed
+  so that archives are reproducible.
+
+Checksums:
+
+  With "-checksum", the digests of every binary and archive are written to
+  a manifest per algorithm in "-checksum-dir": SHA256SUMS, SHA512SUMS or
+  B2SUMS. These are in the format used by sha256sum and friends, so they
+  can be verified with "sha256sum -c SHA256SUMS" from that directory.
 
 Platforms (OS/Arch):
 
@@ -247,19 +744,167 @@ Platforms (OS/Arch):
   expect: "darwin/amd64" would be a valid osarch value. Multiple can be space
   separated. An os/arch pair can begin with "!" to not build for that platform.
 
+  An os/arch pair may also name an architecture variant, which sets GOARM,
+  GOAMD64, GO386, GOMIPS, GOMIPS64 or GOPPC64 for the build, for example
+  "linux/arm/6 linux/arm/7 linux/amd64/v3". Use {{.Variant}} in the
+  output template so that the variants don't overwrite each other. The
+  valid variants are shown by "-osarch-list".
+
   The "-osarch" flag has the highest precedent when determing whether to
   build for a platform. If it is included in the "-osarch" list, it will be
   built even if the specific os and arch is negated in "-os" and "-arch",
   respectively.
 
+  The supported platforms are read from "go tool dist list" for the Go
+  command in use. Older toolchains fall back to a built-in table. The
+  ports in the built-in table, up to Go 1.18, are built by default if
+  the table says so, which includes js/wasm but not linux/arm64. Of the
+  ports added since, first-class ports and ports with cgo support for
+  darwin, freebsd, linux, netbsd, openbsd and windows are built by
+  default, such as linux/loong64. Use "-osarch-list" to see the list,
+  where it came from and which are defaults.
+
+Source Directives:
+
+  A main package can declare its own platforms, output path and ldflags
+  with comments at the start of a line in any of its Go files:
+
+    //gox:platforms linux/* darwin/arm64 !linux/386
+    //gox:output dist/mytool_{{.OS}}_{{.Arch}}
+    //gox:ldflags -s -w
+
+  The platforms are os/arch or os/arch/variant pairs, where the os or arch
+  may be "*", and those starting with "!" are skipped. A pattern that
+  matches no platform Go supports, such as a typo, is an error and the
+  package isn't built. If no platforms were given with the flags or
+  config, the package is built for every platform that matches, whether
+  it is a default or not. Otherwise only the given platforms that match
+  are built. A relative output is relative to the directory of the
+  package. The output and ldflags replace those from the config file for
+  the package, but not "-output" and "-ldflags" given on the command-line,
+  and the "platform" blocks of the config file and the platform overrides
+  below take precedence over them.
+
 Platform Overrides:
 
-  The "-gcflags", "-ldflags" and "-asmflags" options can be overridden per-platform
-  by using environment variables. Gox will look for environment variables
-  in the following format and use those to override values if they exist:
+  Most options can be overridden per-platform by using environment
+  variables. Gox will look for environment variables in the following
+  format and use those to override values if they exist:
 
     GOX_[OS]_[ARCH]_GCFLAGS
     GOX_[OS]_[ARCH]_LDFLAGS
     GOX_[OS]_[ARCH]_ASMFLAGS
+    GOX_[OS]_[ARCH]_TAGS
+    GOX_[OS]_[ARCH]_MOD
+    GOX_[OS]_[ARCH]_BUILDMODE
+    GOX_[OS]_[ARCH]_CGO           "1" or "0", "0" disables cgo for the host
+    GOX_[OS]_[ARCH]_TRIMPATH      "1" or "0"
+    GOX_[OS]_[ARCH]_TIMEOUT       For example to give a slow cgo target longer
+    GOX_[OS]_[ARCH]_ENV_[NAME]    Sets NAME in the environment of go build
+
+  Each can also be set for every platform of an OS with GOX_[OS]_[KEY],
+  for every platform of an architecture with GOX_[ARCH]_[KEY], or for a
+  single variant with GOX_[OS]_[ARCH]_[VARIANT]_[KEY]. When several are
+  set, the more specific one wins, in the order OS, arch, os/arch and
+  then variant. For example, GOX_WINDOWS_LDFLAGS applies to every Windows
+  build except those with a GOX_WINDOWS_[ARCH]_LDFLAGS.
+
+  The flags and tags may be appended to, rather than replaced, by adding
+  "_APPEND" to the name: GOX_WINDOWS_LDFLAGS_APPEND="-H windowsgui" adds
+  to the "-ldflags" given to gox. This works for GCFLAGS, LDFLAGS,
+  ASMFLAGS, TAGS, CGO_CFLAGS, CGO_CXXFLAGS and CGO_LDFLAGS.
+
+Reproducible Builds:
+
+  With "-reproducible", the binaries only depend on the source and the
+  options they're built with, so that anyone building the same commit
+  gets the same bytes. File system paths are removed with "-trimpath",
+  the build ID is removed with "-ldflags=-buildid=", version control
+  information isn't stamped, and GOFLAGS, TZ and the locale are removed
+  from the environment. This needs Go 1.18 or later.
+
+  The date available to templates as {{.Date}} is taken from the
+  SOURCE_DATE_EPOCH environment variable if it is set. This is the number
+  of seconds since the Unix epoch, usually the time of the last commit.
+
+  "-verify-reproducible" implies "-reproducible", and builds every
+  binary twice more, each into its own temporary directory with its own
+  empty build cache, and fails if the two differ. This is slow, since
+  nothing is cached for either build.
+
+Cgo:
+
+  With "-cgo", builds for platforms other than the host need a C
+  cross-compiler. Gox looks for the GCC cross-compilers packaged by most
+  Linux distributions, such as "aarch64-linux-gnu-gcc" or
+  "x86_64-w64-mingw32-gcc", then osxcross for macOS, then "zig cc". Any
+  platform without a compiler is reported before building starts. The C
+  toolchain can be set per-platform with these environment variables,
+  which work like the other platform overrides:
+
+    GOX_[OS]_[ARCH]_CC
+    GOX_[OS]_[ARCH]_CXX
+    GOX_[OS]_[ARCH]_CGO_CFLAGS
+    GOX_[OS]_[ARCH]_CGO_CXXFLAGS
+    GOX_[OS]_[ARCH]_CGO_LDFLAGS
+    GOX_[OS]_[ARCH]_PKG_CONFIG_PATH
+    GOX_[OS]_[ARCH]_SYSROOT
+
+  The sysroot is passed to the compiler and linker with --sysroot and is
+  used as the pkg-config sysroot. These can also be set in the platform
+  blocks of the config file, along with "cgo" to only enable cgo for some
+  platforms.
+
+Config File:
+
+  Instead of a long command-line, settings can be declared in a "gox.hcl"
+  or "gox.json" file in the root of the module, or at the path given with
+  "-config". Use -config="" to ignore the config file. Flags given on the
+  command-line take precedence over the config. An example:
+
+    packages = ["./cmd/..."]
+    osarch   = ["linux/amd64", "darwin/arm64", "windows/amd64"]
+    output   = "dist/{{.Dir}}_{{.OS}}_{{.Arch}}"
+    parallel = 4
+    ldflags  = "-s -w"
+
+    platform "windows/amd64" {
+      ldflags = "-s -w -H windowsgui"
+    }
+
+  The top-level keys match the flags above, as do "os", "arch" and
+  "osarch", which take lists, and "packages", the packages to build when
+  none are given. A relative "output", and package patterns like
+  "./cmd/...", are relative to the config file. The "platform" blocks
+  may override "output", "ldflags", "gcflags", "asmflags", "tags",
+  "buildmode" and "timeout", and add to "x", for a single os/arch pair,
+  or for a single variant such as "linux/arm/7". They may also set the C
+  toolchain with "cgo", "cc", "cxx", "cgo-cflags", "cgo-cxxflags",
+  "cgo-ldflags", "pkg-config-path" and "sysroot". A setting whose flag is
+  given on the command-line isn't overridden by the "platform" blocks,
+  since the command-line always wins.
+  The GOX_[OS]_[ARCH]_* environment variables take precedence over these.
 
 `
+
+// goVersionAtLeast returns true if the Go version, as returned by
+// gox.GoVersion, is at least min. Versions without the "go" prefix, such
+// as development builds, are assumed to be new enough.
+func goVersionAtLeast(versionStr string, min string) (bool, error) {
+	if !strings.HasPrefix(versionStr, "go") {
+		return true, nil
+	}
+
+	// go-version only cares about version numbers
+	current, err := version.NewVersion(versionStr[2:])
+	if err != nil {
+		return false, err
+	}
+
+	constraint, err := version.NewConstraint(">= " + min)
+	if err != nil {
+		panic(err)
+	}
+
+	return constraint.Check(current), nil
+}
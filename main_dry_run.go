@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mitchellh/gox/gox"
+)
+
+// Formats that are supported by -dry-run-format.
+const (
+	dryRunText = "text"
+	dryRunJSON = "json"
+)
+
+// mainDryRun prints what the builder would do, without building
+// anything. Problems that would stop the build are reported as they
+// would be without -dry-run, and make it fail.
+func mainDryRun(builder *gox.Builder, version string, format string, loadErr *gox.PackageLoadError) int {
+	plans, err := builder.Plan(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+
+	if format == dryRunJSON {
+		report := gox.NewPlanReport(version, plans)
+		if loadErr != nil {
+			report.BrokenPackages = loadErr.Packages
+		}
+		if err := gox.WritePlanReport(os.Stdout, report); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return 1
+		}
+	} else {
+		printPlans(os.Stdout, builder.Opts.GoCmd, plans)
+	}
+
+	failed := loadErr != nil
+	for _, plan := range plans {
+		if plan.Err != nil {
+			failed = true
+		}
+	}
+
+	if err := builder.Check(context.Background()); err != nil {
+		failed = true
+		printCheckError(err)
+	}
+
+	if failed {
+		return 1
+	}
+
+	return 0
+}
+
+// printPlans prints each plan as the platform and package, followed by
+// the paths it would write, the environment gox would change and the
+// command it would run.
+func printPlans(w io.Writer, goCmd string, plans []*gox.Plan) {
+	for i, plan := range plans {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+
+		fmt.Fprintf(w, "%s: %s\n", plan.Platform.String(), plan.Package)
+		switch {
+		case plan.Skipped:
+			fmt.Fprintf(w, "    skipped: %s\n", plan.SkipReason)
+			continue
+		case plan.Err != nil:
+			fmt.Fprintf(w, "    error:   %s\n", plan.Err)
+			continue
+		}
+
+		cmd := plan.Command
+		fmt.Fprintf(w, "    output:  %s\n", cmd.Output)
+		if plan.Archive != "" {
+			fmt.Fprintf(w, "    archive: %s\n", plan.Archive)
+		}
+		if cmd.Dir != "" {
+			fmt.Fprintf(w, "    dir:     %s\n", cmd.Dir)
+		}
+		if len(cmd.Unset) > 0 {
+			fmt.Fprintf(w, "    unset:   %s\n", strings.Join(cmd.Unset, " "))
+		}
+		for _, kv := range cmd.Env {
+			fmt.Fprintf(w, "    env:     %s\n", shellQuote(kv))
+		}
+
+		args := make([]string, len(cmd.Args))
+		for i, arg := range cmd.Args {
+			args[i] = shellQuote(arg)
+		}
+		fmt.Fprintf(w, "    command: %s %s\n", shellQuote(goCmd), strings.Join(args, " "))
+	}
+}
+
+// shellQuote quotes s for a POSIX shell if it needs to be, so that the
+// printed commands can be copied and run.
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+
+	safe := true
+	for _, r := range s {
+		if !strings.ContainsRune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_@%+=:,./-", r) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestShellQuote(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected string
+	}{
+		{"", "''"},
+		{"-ldflags", "-ldflags"},
+		{"GOOS=linux", "GOOS=linux"},
+		{"/tmp/foo_linux_amd64", "/tmp/foo_linux_amd64"},
+		{"-s -w", "'-s -w'"},
+		{"-X main.name=it's", `'-X main.name=it'"'"'s'`},
+	}
+
+	for _, tc := range cases {
+		actual := shellQuote(tc.Input)
+		if actual != tc.Expected {
+			t.Fatalf("%q: bad: %s", tc.Input, actual)
+		}
+	}
+}
@@ -2,17 +2,28 @@ package main
 
 import (
 	"fmt"
+	"strings"
+
+	"github.com/mitchellh/gox/gox"
 )
 
-func mainListOSArch(version string) int {
+func mainListOSArch(version string, supported []gox.Platform, source string) int {
 	fmt.Printf(
-		"Supported OS/Arch combinations for %s are shown below. The \"default\"\n"+
-			"boolean means that if you don't specify an OS/Arch, it will be\n"+
-			"included by default. If it isn't a default OS/Arch, you must explicitly\n"+
-			"specify that OS/Arch combo for Gox to use it.\n\n",
-		version)
-	for _, p := range SupportedPlatforms(version) {
-		fmt.Printf("%s\t(default: %v)\n", p.String(), p.Default)
+		"Supported OS/Arch combinations for %s (source: %s) are shown below.\n"+
+			"The \"default\" boolean means that if you don't specify an OS/Arch, it\n"+
+			"will be included by default. If it isn't a default OS/Arch, you must\n"+
+			"explicitly specify that OS/Arch combo for Gox to use it. Ports that\n"+
+			"are newer than the built-in table are defaults if they're first-class,\n"+
+			"or have cgo support on a desktop or server OS. Variants can be\n"+
+			"built with -osarch, for example \"linux/arm/7\".\n\n",
+		version, source)
+	for _, p := range supported {
+		variants := ""
+		if vs := gox.ArchVariants(p.Arch); len(vs) > 0 {
+			variants = fmt.Sprintf("\t(variants: %s)", strings.Join(vs, " "))
+		}
+
+		fmt.Printf("%s\t(default: %v)%s\n", p.String(), p.Default, variants)
 	}
 
 	return 0
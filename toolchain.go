@@ -10,11 +10,12 @@ import (
 	"runtime"
 	"sync"
 
+	"github.com/mitchellh/gox/gox"
 	"github.com/mitchellh/iochan"
 )
 
 // The "main" method for when the toolchain build is requested.
-func mainBuildToolchain(parallel int, platformFlag PlatformFlag, verbose bool) int {
+func mainBuildToolchain(parallel int, platformFlag gox.PlatformFlag, verbose bool) int {
 	if _, err := exec.LookPath("go"); err != nil {
 		fmt.Fprintf(os.Stderr, "You must have Go already built for your native platform\n")
 		fmt.Fprintf(os.Stderr, "and the `go` binary on the PATH to build toolchains.\n")
@@ -22,7 +23,7 @@ func mainBuildToolchain(parallel int, platformFlag PlatformFlag, verbose bool) i
 	}
 
 	// If we're version 1.5 or greater, then we don't need to do this anymore!
-	versionParts, err := GoVersionParts()
+	versionParts, err := gox.GoVersionParts()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error reading Go version: %s", err)
 		return 1
@@ -35,13 +36,13 @@ func mainBuildToolchain(parallel int, platformFlag PlatformFlag, verbose bool) i
 		return 1
 	}
 
-	version, err := GoVersion()
+	version, err := gox.GoVersion()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error reading Go version: %s", err)
 		return 1
 	}
 
-	root, err := GoRoot()
+	root, err := gox.GoRoot()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error finding GOROOT: %s\n", err)
 		return 1
@@ -53,7 +54,7 @@ func mainBuildToolchain(parallel int, platformFlag PlatformFlag, verbose bool) i
 	}
 
 	// Determine the platforms we're building the toolchain for.
-	platforms := platformFlag.Platforms(SupportedPlatforms(version))
+	platforms := platformFlag.Platforms(gox.SupportedPlatforms(version))
 
 	// The toolchain build can't be parallelized.
 	if parallel > 1 {
@@ -69,7 +70,7 @@ func mainBuildToolchain(parallel int, platformFlag PlatformFlag, verbose bool) i
 	semaphore := make(chan int, parallel)
 	for _, platform := range platforms {
 		wg.Add(1)
-		go func(platform Platform) {
+		go func(platform gox.Platform) {
 			err := buildToolchain(&wg, semaphore, root, platform, verbose)
 			if err != nil {
 				errorLock.Lock()
@@ -91,7 +92,7 @@ func mainBuildToolchain(parallel int, platformFlag PlatformFlag, verbose bool) i
 	return 0
 }
 
-func buildToolchain(wg *sync.WaitGroup, semaphore chan int, root string, platform Platform, verbose bool) error {
+func buildToolchain(wg *sync.WaitGroup, semaphore chan int, root string, platform gox.Platform, verbose bool) error {
 	defer wg.Done()
 	semaphore <- 1
 	defer func() { <-semaphore }()